@@ -0,0 +1,52 @@
+// Package admin exposes small operational HTTP endpoints for operators, alongside the
+// periodic jobs started from main.
+package admin
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"github.com/encounter/decompal/config"
+	"github.com/encounter/decompal/database"
+	"goji.io"
+	"goji.io/pat"
+	"net/http"
+)
+
+// adminSecretHeader carries the shared secret authenticating requests to admin routes.
+const adminSecretHeader = "X-Decompal-Admin-Secret"
+
+// RegisterRoutes mounts the admin endpoints, guarded by cfg.AdminSecret. An empty
+// AdminSecret disables the routes entirely rather than leaving them open.
+func RegisterRoutes(mux *goji.Mux, db *database.DB, cfg *config.AppConfig) {
+	mux.Handle(pat.Post("/admin/gc"), requireAdminSecret(cfg.AdminSecret, gcHandler(db)))
+}
+
+// requireAdminSecret rejects requests whose X-Decompal-Admin-Secret header doesn't match
+// secret, using a constant-time comparison, the same defense-in-depth other write/trigger
+// endpoints in this repo use (e.g. artifactsource/http.go's webhook signature check). An
+// empty secret rejects every request, since there's nothing safe to compare against.
+func requireAdminSecret(secret string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" || !hmac.Equal([]byte(r.Header.Get(adminSecretHeader)), []byte(secret)) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// gcHandler runs database.GCReportUnits on demand, on top of the periodic goroutine
+// started from main. Pass ?dry_run=true to see what would be collected without deleting
+// anything.
+func gcHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		stats, err := db.GCReportUnits(r.Context(), dryRun)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stats)
+	}
+}
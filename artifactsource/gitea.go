@@ -0,0 +1,99 @@
+package artifactsource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/config"
+	"github.com/encounter/decompal/forge"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+)
+
+// GiteaSource lists and downloads Actions artifacts from a self-hosted Gitea instance.
+// Gitea Actions mirrors the GitHub Actions artifact API closely enough that the two
+// endpoints below are all it takes; GiteaSource doesn't implement forge.Client, since
+// decompal doesn't yet publish comments or check runs against Gitea.
+type GiteaSource struct {
+	host  string
+	token string
+}
+
+func NewGiteaSource(cfg config.SourceConfig) *GiteaSource {
+	return &GiteaSource{host: cfg.Host, token: cfg.Token}
+}
+
+type giteaArtifactList struct {
+	Artifacts []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"artifacts"`
+}
+
+func (s *GiteaSource) ListWorkflowRunArtifacts(
+	ctx context.Context,
+	project *common.Project,
+	run forge.WorkflowRun,
+) ([]forge.Artifact, error) {
+	url := fmt.Sprintf(
+		"https://%s/api/v1/repos/%s/%s/actions/tasks/%d/artifacts",
+		s.host, project.Owner, project.Name, run.ID,
+	)
+	var list giteaArtifactList
+	if err := s.getJSON(ctx, url, &list); err != nil {
+		return nil, errors.Wrap(err, "failed to list workflow run artifacts")
+	}
+	result := make([]forge.Artifact, 0, len(list.Artifacts))
+	for _, a := range list.Artifacts {
+		result = append(result, forge.Artifact{ID: a.ID, Name: a.Name})
+	}
+	return result, nil
+}
+
+func (s *GiteaSource) DownloadArtifact(
+	ctx context.Context,
+	project *common.Project,
+	artifact forge.Artifact,
+) (io.ReadCloser, error) {
+	url := fmt.Sprintf(
+		"https://%s/api/v1/repos/%s/%s/actions/tasks/artifacts/%d",
+		s.host, project.Owner, project.Name, artifact.ID,
+	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create download request")
+	}
+	s.authenticate(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download artifact")
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, errors.Errorf("failed to download artifact: unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *GiteaSource) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	s.authenticate(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (s *GiteaSource) authenticate(req *http.Request) {
+	req.Header.Set("Authorization", "token "+s.token)
+}
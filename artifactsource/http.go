@@ -0,0 +1,121 @@
+package artifactsource
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/encounter/decompal/broker"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/config"
+	"github.com/encounter/decompal/database"
+	"github.com/encounter/decompal/objdiff"
+	"github.com/rs/zerolog"
+	"goji.io"
+	"goji.io/pat"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxUploadBytes bounds a single report upload. objdiff reports are small JSON/protobuf
+// documents even for large projects, so this is generous headroom while still capping
+// worst-case memory use for a request whose signature hasn't been checked yet.
+const maxUploadBytes = 64 << 20 // 64 MiB
+
+// RegisterHTTPRoute mounts the HMAC-signed report upload endpoint used by CI jobs that
+// can't go through a forge-specific path, e.g. a self-hosted runner that isn't fronted by
+// the GitHub App or a supported forge. The uploaded body is the same report archive a
+// GitHub Actions artifact would contain.
+//
+// The project must already exist (created via the normal GitHub App onboarding path);
+// this route only attaches an additional report to it, it doesn't create new projects.
+func RegisterHTTPRoute(mux *goji.Mux, db *database.DB, cfg *config.AppConfig, pub broker.Publisher, logger zerolog.Logger) {
+	mux.Handle(pat.Post("/projects/:owner/:name/:version/reports"), uploadHandler(db, cfg, pub, logger))
+}
+
+func uploadHandler(db *database.DB, cfg *config.AppConfig, pub broker.Publisher, logger zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := pat.Param(r, "owner")
+		name := pat.Param(r, "name")
+		version := pat.Param(r, "version")
+		commitSha := r.URL.Query().Get("commit")
+		if commitSha == "" {
+			http.Error(w, "missing commit query parameter", http.StatusBadRequest)
+			return
+		}
+
+		source := SelectSource(cfg, "http", r.Host)
+		if source == nil {
+			http.Error(w, "no http source configured for this host", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxUploadBytes))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if !validSignature(source.Secret, r.Header.Get("X-Decompal-Signature"), body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		project, err := db.GetProjectByName(r.Context(), owner, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if project == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		report, err := objdiff.FindReportFile(logger, bytes.NewReader(body), cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if report == nil {
+			http.Error(w, "no report file found in upload", http.StatusBadRequest)
+			return
+		}
+
+		file := &common.ReportFile{
+			Project: project,
+			Version: version,
+			Commit:  &common.Commit{Sha: commitSha, Timestamp: time.Now()},
+			Report:  report,
+		}
+		if err = db.InsertReport(r.Context(), file); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		event := broker.Event{
+			Project:   fmt.Sprintf("%s/%s", owner, name),
+			Version:   version,
+			Commit:    commitSha,
+			Timestamp: file.Commit.Timestamp,
+			Measures:  report.Measures,
+			ReportURL: fmt.Sprintf("/projects/%s/%s/%s/badge.svg", owner, name, version),
+		}
+		if err = pub.Publish(r.Context(), event); err != nil {
+			logger.Warn().Err(err).Msg("Failed to publish report event")
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func validSignature(secret, header string, body []byte) bool {
+	const prefix = "sha256="
+	if secret == "" || len(header) <= len(prefix) || header[:len(prefix)] != prefix {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(header[len(prefix):]), []byte(expected))
+}
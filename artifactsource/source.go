@@ -0,0 +1,25 @@
+// Package artifactsource supports CI systems that can't go through the GitHub App webhook
+// path: an objdiff.ArtifactSource implementation for Gitea Actions, and a plain HTTP upload
+// endpoint for any CI job that can just POST a report archive directly. The upload endpoint
+// parses the posted archive itself and is not an objdiff.ArtifactSource.
+package artifactsource
+
+import "github.com/encounter/decompal/config"
+
+// SelectSource finds the SourceConfig configured for a given source type and host, or nil
+// if none matches.
+//
+// workflow_run.go doesn't call this yet: incoming deliveries always arrive through
+// githubapp.NewDefaultEventDispatcher, which only understands GitHub's webhook format, so
+// there's no event to dispatch by hostname for a GitLab/Gitea webhook yet. Wiring a
+// non-GitHub forge's own webhook shape into event ingestion is a larger change left for a
+// follow-up; SelectSource exists so that ingestion, and the HTTP upload route below, have
+// somewhere to resolve a project's SourceConfig once it's needed.
+func SelectSource(cfg *config.AppConfig, sourceType, host string) *config.SourceConfig {
+	for i, s := range cfg.Sources {
+		if s.Type == sourceType && s.Host == host {
+			return &cfg.Sources[i]
+		}
+	}
+	return nil
+}
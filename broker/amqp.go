@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/encounter/decompal/config"
+	"github.com/pkg/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQPPublisher publishes Events to an AMQP 0.9.1 exchange, e.g. RabbitMQ.
+type AMQPPublisher struct {
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	exchange   string
+	routingKey string
+}
+
+func newAMQPPublisher(cfg config.BrokerConfig) (*AMQPPublisher, error) {
+	conn, err := amqp.Dial(cfg.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to broker")
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to open broker channel")
+	}
+	return &AMQPPublisher{
+		conn:       conn,
+		channel:    channel,
+		exchange:   cfg.Exchange,
+		routingKey: cfg.RoutingKey,
+	}, nil
+}
+
+func (p *AMQPPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+	err = p.channel.PublishWithContext(
+		ctx,
+		p.exchange,
+		p.routingKey,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to publish event")
+	}
+	return nil
+}
+
+func (p *AMQPPublisher) Close() error {
+	if err := p.channel.Close(); err != nil {
+		return err
+	}
+	return p.conn.Close()
+}
@@ -0,0 +1,47 @@
+// Package broker fans out report-ingest events to an external message broker, so
+// downstream consumers (dashboards, chat bots, progress-tracking sites) can react to new
+// reports and diffs without polling the database. AMQP 0.9.1 is the only implementation
+// today; Publisher exists so NATS or Redis Streams can be added later without touching
+// callers.
+package broker
+
+import (
+	"context"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/config"
+	"time"
+)
+
+// Event is the JSON envelope published whenever a report is ingested or a diff is
+// computed against a previous one.
+type Event struct {
+	Project   string           `json:"project"` // "owner/name"
+	Version   string           `json:"version"`
+	Commit    string           `json:"commit"`
+	Timestamp time.Time        `json:"timestamp"`
+	Measures  *common.Measures `json:"measures,omitempty"`
+	// ReportURL is a path on decompal's own server. It points at the version's badge.svg
+	// route, which serves the latest matched code percentage, not the full report.
+	ReportURL string `json:"report_url"`
+}
+
+// Publisher fans out Events. Publish errors are logged by callers rather than treated as
+// fatal, since a broker outage shouldn't block report ingestion.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+	Close() error
+}
+
+// NewPublisher returns an AMQPPublisher connected per cfg, or a no-op Publisher if
+// cfg.URL is empty.
+func NewPublisher(cfg config.BrokerConfig) (Publisher, error) {
+	if cfg.URL == "" {
+		return noopPublisher{}, nil
+	}
+	return newAMQPPublisher(cfg)
+}
+
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(context.Context, Event) error { return nil }
+func (noopPublisher) Close() error                         { return nil }
@@ -0,0 +1,288 @@
+// Package checks turns objdiff Changes into the pieces of a GitHub Check Run: a Markdown
+// summary, per-function annotations, and a pass/neutral/fail conclusion.
+package checks
+
+import (
+	"fmt"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/forge"
+	"strings"
+)
+
+const (
+	ConclusionSuccess = "success"
+	ConclusionNeutral = "neutral"
+	ConclusionFailure = "failure"
+)
+
+// Conclusion derives a check run's conclusion from a regression threshold: any unit whose
+// fuzzy match percentage drops by more than regressionFailThreshold fails the check; a
+// smaller regression is reported but doesn't fail the build; no regressions succeeds.
+func Conclusion(changes *common.Changes, regressionFailThreshold float32) string {
+	worst := float32(0)
+	for _, unit := range changes.Units {
+		if unit.From == nil || unit.To == nil {
+			continue
+		}
+		regression := unit.From.FuzzyMatchPercent - unit.To.FuzzyMatchPercent
+		if regression > worst {
+			worst = regression
+		}
+	}
+	switch {
+	case worst <= 0:
+		return ConclusionSuccess
+	case worst > regressionFailThreshold:
+		return ConclusionFailure
+	default:
+		return ConclusionNeutral
+	}
+}
+
+// Annotations returns one annotation per function whose match percentage changed, pointing
+// at the compilation unit it belongs to since objdiff doesn't report a source file/line.
+func Annotations(changes *common.Changes) []forge.CheckAnnotation {
+	annotations := make([]forge.CheckAnnotation, 0)
+	for _, unit := range changes.Units {
+		for _, item := range unit.Functions {
+			if annotation, ok := functionAnnotation(unit.Name, item); ok {
+				annotations = append(annotations, annotation)
+			}
+		}
+	}
+	return annotations
+}
+
+func functionAnnotation(unitName string, item *common.ChangeItem) (forge.CheckAnnotation, bool) {
+	var fromPercent, toPercent float32
+	if item.From != nil {
+		fromPercent = item.From.FuzzyMatchPercent
+	}
+	if item.To != nil {
+		toPercent = item.To.FuzzyMatchPercent
+	}
+	if fromPercent == toPercent {
+		return forge.CheckAnnotation{}, false
+	}
+
+	level := "notice"
+	if toPercent < fromPercent {
+		level = "warning"
+	}
+	return forge.CheckAnnotation{
+		// objdiff doesn't expose a source file/line for functions, so fall back to the
+		// unit name.
+		Path:    unitName,
+		Line:    1,
+		Level:   level,
+		Title:   item.Name,
+		Message: fmt.Sprintf("%.2f%% -> %.2f%% match", fromPercent, toPercent),
+	}, true
+}
+
+// Summary renders changes as the Markdown tables shown in both the check run's output and
+// the PR comment body.
+func Summary(changes *common.Changes) string {
+	out := "### Overall\n\n"
+	overallTable := measuresTable(changes.From, changes.To)
+	if overallTable == "" {
+		if len(changes.Units) == 0 {
+			return ""
+		}
+		out += "No changes\n\n"
+	} else {
+		out += overallTable + "\n\n"
+	}
+	for _, unit := range changes.Units {
+		out += fmt.Sprintf("---\n### `%s`\n\n", unit.Name)
+		unitTable := measuresTable(unit.From, unit.To)
+		if unitTable != "" {
+			out += unitTable + "\n\n"
+		}
+		functionsTable := changeItemTable("Functions", unit.Functions)
+		if functionsTable != "" {
+			out += functionsTable + "\n\n"
+		}
+	}
+	return out
+}
+
+func changeItemTable(name string, items []*common.ChangeItem) string {
+	header := fmt.Sprintf("|%s|Previous|Current|Change|\n|-|-|-|-|", name)
+	rows := make([]string, 0)
+	for _, item := range items {
+		row := changeItemInfoRow(item)
+		if row != "" {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+	return header + "\n" + strings.Join(rows, "\n")
+}
+
+const (
+	incArrow = "${\\color{green}▲}$"
+	decArrow = "${\\color{red}▼}$"
+)
+
+func floatArrow(diff float32) string {
+	if diff > 0 {
+		return " " + incArrow
+	}
+	if diff < 0 {
+		return " " + decArrow
+	}
+	return ""
+}
+
+func intArrow(diff int64) string {
+	if diff > 0 {
+		return " " + incArrow
+	}
+	if diff < 0 {
+		return " " + decArrow
+	}
+	return ""
+}
+
+func changeItemInfoRow(item *common.ChangeItem) string {
+	var fromPercent, toPercent float32
+	if item.From != nil {
+		fromPercent = item.From.FuzzyMatchPercent
+	}
+	if item.To != nil {
+		toPercent = item.To.FuzzyMatchPercent
+	}
+	if fromPercent == toPercent {
+		return ""
+	}
+	diff := toPercent - fromPercent
+	return fmt.Sprintf(
+		"|`%s`|%.2f%%|%.2f%%|%.2f%%%s|",
+		item.Name,
+		fromPercent,
+		toPercent,
+		diff,
+		floatArrow(diff),
+	)
+}
+
+func measuresTable(prev, curr *common.Measures) string {
+	if prev == nil && curr == nil {
+		return ""
+	} else if prev == nil {
+		// TODO: added
+		prev = &common.Measures{}
+	} else if curr == nil {
+		// TODO: removed
+		curr = &common.Measures{}
+	}
+	header := "|Metric|Previous|Current|Change|\n|-|-|-|-|"
+	rows := make([]string, 0)
+	if prev.FuzzyMatchPercent != curr.FuzzyMatchPercent {
+		rows = append(rows, floatRow("Fuzzy match", prev.FuzzyMatchPercent, curr.FuzzyMatchPercent))
+	}
+	if prev.TotalCode != curr.TotalCode {
+		rows = append(rows, sizeRow("Total code", prev.TotalCode, curr.TotalCode))
+	}
+	if prev.MatchedCode != curr.MatchedCode ||
+		prev.MatchedCodePercent != curr.MatchedCodePercent {
+		rows = append(rows, intPercentRow(
+			"Matched code",
+			prev.MatchedCode,
+			prev.MatchedCodePercent,
+			curr.MatchedCode,
+			curr.MatchedCodePercent,
+		))
+	}
+	if prev.TotalData != curr.TotalData {
+		rows = append(rows, sizeRow("Total data", prev.TotalData, curr.TotalData))
+	}
+	if prev.MatchedData != curr.MatchedData ||
+		prev.MatchedDataPercent != curr.MatchedDataPercent {
+		rows = append(rows, intPercentRow(
+			"Matched data",
+			prev.MatchedData,
+			prev.MatchedDataPercent,
+			curr.MatchedData,
+			curr.MatchedDataPercent,
+		))
+	}
+	if prev.TotalFunctions != curr.TotalFunctions {
+		rows = append(rows, intRow("Total functions", prev.TotalFunctions, curr.TotalFunctions))
+	}
+	if prev.MatchedFunctions != curr.MatchedFunctions ||
+		prev.MatchedFunctionsPercent != curr.MatchedFunctionsPercent {
+		rows = append(rows, intPercentRow(
+			"Matched functions",
+			uint64(prev.MatchedFunctions),
+			prev.MatchedFunctionsPercent,
+			uint64(curr.MatchedFunctions),
+			curr.MatchedFunctionsPercent,
+		))
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+	return header + "\n" + strings.Join(rows, "\n")
+}
+
+func floatRow(name string, prev, curr float32) string {
+	diff := curr - prev
+	return fmt.Sprintf(
+		"|%s|%.2f%%|%.2f%%|%.2f%%%s|",
+		name,
+		prev,
+		curr,
+		diff,
+		floatArrow(diff),
+	)
+}
+
+func intRow(name string, prev, curr uint32) string {
+	diff := int64(curr) - int64(prev)
+	return fmt.Sprintf(
+		"|%s|%d|%d|%d%s|",
+		name,
+		prev,
+		curr,
+		diff,
+		intArrow(diff),
+	)
+}
+
+func sizeRow(name string, prev, curr uint64) string {
+	// TODO: format size
+	diff := int64(curr) - int64(prev)
+	return fmt.Sprintf(
+		"|%s|%d|%d|%d%s|",
+		name,
+		prev,
+		curr,
+		diff,
+		intArrow(diff),
+	)
+}
+
+func intPercentRow(
+	name string,
+	prevInt uint64,
+	prevPercent float32,
+	currInt uint64,
+	currPercent float32,
+) string {
+	diff := int64(currInt) - int64(prevInt)
+	return fmt.Sprintf(
+		"|%s|%d (%.2f%%)|%d (%.2f%%)|%d (%.2f%%)%s|",
+		name,
+		prevInt,
+		prevPercent,
+		currInt,
+		currPercent,
+		diff,
+		currPercent-prevPercent,
+		intArrow(diff),
+	)
+}
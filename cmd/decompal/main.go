@@ -2,22 +2,30 @@ package main
 
 import (
 	"context"
-	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/admin"
+	"github.com/encounter/decompal/artifactsource"
+	"github.com/encounter/decompal/broker"
 	"github.com/encounter/decompal/config"
+	"github.com/encounter/decompal/dashboard"
 	"github.com/encounter/decompal/database"
+	"github.com/encounter/decompal/graphql"
 	"github.com/encounter/decompal/handlers"
-	"github.com/encounter/decompal/objdiff"
-	"github.com/google/go-github/v63/github"
+	"github.com/encounter/decompal/jobqueue"
 	"github.com/gregjones/httpcache"
 	"github.com/palantir/go-baseapp/baseapp"
 	"github.com/palantir/go-githubapp/githubapp"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"goji.io/pat"
-	"google.golang.org/protobuf/proto"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+// defaultGCInterval is used when AppConfig.GCInterval is unset or invalid.
+const defaultGCInterval = 1 * time.Hour
+
 func main() {
 	// Load configuration from a file
 	cfg, err := config.ReadConfig("config.yml")
@@ -41,6 +49,14 @@ func main() {
 		logger.Fatal().Err(err).Msg("failed to create server")
 	}
 
+	// Connect the report-ingest event publisher. This is a no-op unless cfg.App.Broker.URL
+	// is set.
+	pub, err := broker.NewPublisher(cfg.App.Broker)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to connect to broker")
+	}
+	defer pub.Close()
+
 	// Create GitHub app client
 	cc, err := githubapp.NewDefaultCachingClientCreator(
 		cfg.GitHub,
@@ -52,111 +68,88 @@ func main() {
 		logger.Fatal().Err(err).Msg("failed to create GitHub app client")
 	}
 
-	// --- START TESTING ---
-	client, err := cc.NewTokenClient(cfg.App.GitHubToken)
-	if err != nil {
-		logger.Fatal().Err(err).Msg("failed to create GitHub app client")
-	}
-	runs := make([]*github.WorkflowRun, 0)
-	page := 0
-	ctx := context.Background()
-	for {
-		result, _, err := client.Actions.ListWorkflowRunsByFileName(ctx, "zeldaret", "tww", "build.yml", &github.ListWorkflowRunsOptions{
-			Branch:              "main",
-			Event:               "push",
-			Status:              "completed",
-			ExcludePullRequests: true,
-			ListOptions: github.ListOptions{
-				Page:    page,
-				PerPage: 10,
-			},
-		})
-		if err != nil {
-			logger.Fatal().Err(err).Msg("failed to list workflow runs by file name")
-		}
-		runs = append(runs, result.WorkflowRuns...)
-		logger.Info().Msgf("Found %d workflow runs", len(runs))
-		found := false
-		for _, run := range result.WorkflowRuns {
-			if run.GetID() == 9983071101 {
-				found = true
-				break
-			}
-		}
-		if found {
-			break
-		}
-		page++
+	// Register the progress dashboard, badge, and JSON API routes
+	dashboard.RegisterRoutes(server.Mux(), db)
+
+	// Register the read-only GraphQL query API over stored reports
+	if err = graphql.RegisterRoutes(server.Mux(), db, &cfg.App); err != nil {
+		logger.Fatal().Err(err).Msg("failed to register graphql routes")
 	}
-	project := &common.Project{
-		ID:    689343905,
-		Owner: "zeldaret",
-		Name:  "tww",
+
+	// Register the HMAC-signed report upload endpoint for CI jobs that can't go through
+	// a forge-specific path.
+	artifactsource.RegisterHTTPRoute(server.Mux(), db, &cfg.App, pub, logger)
+
+	// Register the on-demand GC admin endpoint.
+	admin.RegisterRoutes(server.Mux(), db, &cfg.App)
+
+	// Register GitHub webhook handlers. taskCtx is canceled on SIGINT/SIGTERM so that
+	// in-flight background tasks can wind down instead of being killed outright.
+	taskCtx, taskStop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer taskStop()
+	taskRunner := handlers.NewTaskRunner(db)
+
+	// Start the persistent job queue used for long-running pull_request/workflow_run/
+	// check_suite processing, so large artifact fetches aren't killed at a fixed deadline
+	// and failures are retried with backoff instead of dropped.
+	jobs := jobqueue.New(db, cfg.App.JobConcurrency)
+	prHandler := handlers.NewPullRequestHandler(cc, &cfg.App, db, taskCtx, taskRunner, pub, jobs)
+	workflowRunHandler := handlers.NewWorkflowRunHandler(cc, &cfg.App, db, taskCtx, taskRunner, pub, jobs)
+	if err = jobs.Start(taskCtx); err != nil {
+		logger.Fatal().Err(err).Msg("failed to start job queue")
 	}
-	for _, run := range runs {
-		logger.Info().Msgf("Processing workflow run %d (%s)", run.GetID(), run.GetCreatedAt().String())
-		ghc, _, err := client.Git.GetCommit(ctx, project.Owner, project.Name, run.GetHeadSHA())
-		if err != nil {
-			logger.Fatal().Err(err).Msg("failed to get commit")
-		}
-		commit := &common.Commit{
-			Sha:       ghc.GetSHA(),
-			Timestamp: ghc.GetCommitter().GetDate().Time,
-		}
-		reports, err := objdiff.FetchReportFiles(ctx, db, logger, client, project, commit, run.GetID())
-		if err != nil {
-			logger.Fatal().Err(err).Msg("failed to fetch report files")
-		}
-		for _, report := range reports {
-			//err = db.InsertReport(ctx, &report)
-			//if err != nil {
-			//	logger.Fatal().Err(err).Msg("failed to insert report")
-			//}
-			fetched, err := db.GetReport(ctx, report.Project.ID, report.Version, commit.Sha)
-			if err != nil {
-				logger.Fatal().Err(err).Msg("failed to get report")
-			}
-			if *project != *fetched.Project {
-				logger.Fatal().Msg("fetched project does not match inserted project")
-			}
-			if report.Version != fetched.Version {
-				logger.Fatal().Msg("fetched version does not match inserted version")
-			}
-			if *commit != *fetched.Commit {
-				logger.Fatal().Msg("fetched commit does not match inserted commit")
-			}
-			if !proto.Equal(fetched.Report, report.Report) {
-				if !proto.Equal(fetched.Report.Measures, report.Report.Measures) {
-					logger.Error().Msg("measures do not match")
-				}
-				if len(fetched.Report.Units) != len(report.Report.Units) {
-					logger.Error().Msgf("unit count does not match %d != %d", len(fetched.Report.Units), len(report.Report.Units))
-				} else {
-					for i, unit := range report.Report.Units {
-						if !proto.Equal(fetched.Report.Units[i], unit) {
-							logger.Error().Msgf("unit %d does not match", i)
-						}
-					}
-				}
-				logger.Fatal().Msg("fetched report does not match inserted report")
-			}
-		}
-		if run.GetID() == 9983071101 {
-			logger.Info().Msg("Stopping at run 9983071101")
-			break
+
+	// Replay any deliveries left pending by a previous process before accepting new ones.
+	eventHandlers := map[string]githubapp.EventHandler{}
+	for _, h := range []githubapp.EventHandler{prHandler, workflowRunHandler} {
+		for _, event := range h.Handles() {
+			eventHandlers[event] = h
 		}
 	}
-	// --- END TESTING ---
+	if err = taskRunner.Replay(taskCtx, eventHandlers); err != nil {
+		logger.Error().Err(err).Msg("failed to replay pending deliveries")
+	}
 
-	// Register GitHub webhook handlers
-	taskCtx, taskCancel := context.WithCancel(ctx)
-	defer taskCancel()
 	server.Mux().Handle(pat.Post(githubapp.DefaultWebhookRoute), githubapp.NewDefaultEventDispatcher(
 		cfg.GitHub,
-		handlers.NewPullRequestHandler(cc, &cfg.App, db, taskCtx),
-		handlers.NewWorkflowRunHandler(cc, &cfg.App, db, taskCtx),
+		prHandler,
+		workflowRunHandler,
 	))
 
+	// Wait for in-flight deliveries to finish processing once a shutdown signal arrives.
+	go func() {
+		<-taskCtx.Done()
+		logger.Info().Msg("shutdown signal received, waiting for in-flight deliveries")
+		jobs.Wait()
+	}()
+
+	// Periodically collect report_units rows orphaned by overwritten or deleted reports.
+	gcInterval, err := time.ParseDuration(cfg.App.GCInterval)
+	if err != nil || gcInterval <= 0 {
+		gcInterval = defaultGCInterval
+	}
+	go func() {
+		ticker := time.NewTicker(gcInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-taskCtx.Done():
+				return
+			case <-ticker.C:
+				stats, err := db.GCReportUnits(taskCtx, false)
+				if err != nil {
+					logger.Error().Err(err).Msg("failed to collect orphaned report units")
+					continue
+				}
+				logger.Info().
+					Int64("orphaned_units", stats.OrphanedUnits).
+					Int64("cache_hits", stats.CacheHits).
+					Int64("cache_inserts", stats.CacheInserts).
+					Msg("Collected orphaned report units")
+			}
+		}
+	}()
+
 	// Start the server (blocking)
 	if err = server.Start(); err != nil {
 		logger.Fatal().Err(err).Msg("server failed")
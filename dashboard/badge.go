@@ -0,0 +1,57 @@
+package dashboard
+
+import "fmt"
+
+// renderBadge draws a shields.io-style flat badge: a dark label block on the left and a
+// colored value block on the right.
+func renderBadge(label, value, color string) string {
+	labelWidth := textWidth(label)
+	valueWidth := textWidth(value)
+	totalWidth := labelWidth + valueWidth
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[5]s: %[6]s">
+  <linearGradient id="s" x2="0" y2="100%%">
+    <stop offset="0" stop-color="#bbb" stop-opacity=".1"/>
+    <stop offset="1" stop-opacity=".1"/>
+  </linearGradient>
+  <mask id="r">
+    <rect width="%[1]d" height="20" rx="3" fill="#fff"/>
+  </mask>
+  <g mask="url(#r)">
+    <rect width="%[2]d" height="20" fill="#555"/>
+    <rect x="%[2]d" width="%[3]d" height="20" fill="%[4]s"/>
+    <rect width="%[1]d" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,sans-serif" font-size="11">
+    <text x="%[7]d" y="14">%[5]s</text>
+    <text x="%[8]d" y="14">%[6]s</text>
+  </g>
+</svg>
+`,
+		totalWidth, labelWidth, valueWidth, color,
+		label, value,
+		labelWidth/2, labelWidth+valueWidth/2,
+	)
+}
+
+// textWidth approximates the rendered width of a shields.io label/message at 11px
+// Verdana, padded the same way shields.io pads its flat badges.
+func textWidth(s string) int {
+	return len(s)*6 + 20
+}
+
+// badgeColor picks a shields.io-style color for a match percentage.
+func badgeColor(percent float32) string {
+	switch {
+	case percent >= 90:
+		return "#4c1"
+	case percent >= 75:
+		return "#97ca00"
+	case percent >= 50:
+		return "#dfb317"
+	case percent >= 25:
+		return "#fe7d37"
+	default:
+		return "#e05d44"
+	}
+}
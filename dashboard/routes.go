@@ -0,0 +1,180 @@
+// Package dashboard serves a historical progress dashboard, a shields.io-compatible
+// badge, and the JSON API backing both, all read directly from the reports already
+// stored by database.DB.
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/database"
+	"goji.io"
+	"goji.io/pat"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// RegisterRoutes mounts the dashboard, badge, and progress API routes on mux.
+func RegisterRoutes(mux *goji.Mux, db *database.DB) {
+	mux.Handle(pat.Get("/projects/:owner/:name/progress.json"), progressHandler(db))
+	mux.Handle(pat.Get("/projects/:owner/:name/:version/badge.svg"), badgeHandler(db))
+	mux.Handle(pat.Get("/projects/:owner/:name/dashboard"), dashboardHandler(db))
+}
+
+type seriesPoint struct {
+	Timestamp string   `json:"timestamp"`
+	Commit    string   `json:"commit"`
+	Measures  measures `json:"measures"`
+}
+
+type measures struct {
+	FuzzyMatchPercent       float32 `json:"fuzzy_match_percent"`
+	MatchedCodePercent      float32 `json:"matched_code_percent"`
+	MatchedFunctionsPercent float32 `json:"matched_functions_percent"`
+}
+
+type progressResponse struct {
+	Owner    string                   `json:"owner"`
+	Name     string                   `json:"name"`
+	Versions map[string][]seriesPoint `json:"versions"`
+}
+
+func progressHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, err := lookupProject(w, r, db)
+		if err != nil || project == nil {
+			return
+		}
+
+		versions, err := db.ListVersions(r.Context(), project.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp := progressResponse{
+			Owner:    project.Owner,
+			Name:     project.Name,
+			Versions: make(map[string][]seriesPoint, len(versions)),
+		}
+		for _, version := range versions {
+			points, err := db.GetProgressSeries(r.Context(), project.ID, version)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			series := make([]seriesPoint, 0, len(points))
+			for _, point := range points {
+				series = append(series, seriesPoint{
+					Timestamp: point.Timestamp.UTC().Format("2006-01-02T15:04:05Z"),
+					Commit:    point.Commit,
+					Measures: measures{
+						FuzzyMatchPercent:       point.Measures.GetFuzzyMatchPercent(),
+						MatchedCodePercent:      point.Measures.GetMatchedCodePercent(),
+						MatchedFunctionsPercent: point.Measures.GetMatchedFunctionsPercent(),
+					},
+				})
+			}
+			resp.Versions[version] = series
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func badgeHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, err := lookupProject(w, r, db)
+		if err != nil || project == nil {
+			return
+		}
+		version := pat.Param(r, "version")
+
+		points, err := db.GetProgressSeries(r.Context(), project.ID, version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(points) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		percent := points[len(points)-1].Measures.GetMatchedCodePercent()
+		value := strconv.FormatFloat(float64(percent), 'f', 2, 32) + "%"
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = fmt.Fprint(w, renderBadge("decomp", value, badgeColor(percent)))
+	}
+}
+
+func dashboardHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, err := lookupProject(w, r, db)
+		if err != nil || project == nil {
+			return
+		}
+
+		versions, err := db.ListVersions(r.Context(), project.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sort.Strings(versions)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, dashboardTemplate, project.Owner, project.Name, project.Owner, project.Name)
+	}
+}
+
+func lookupProject(w http.ResponseWriter, r *http.Request, db *database.DB) (*common.Project, error) {
+	owner := pat.Param(r, "owner")
+	name := pat.Param(r, "name")
+	project, err := db.GetProjectByName(r.Context(), owner, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if project == nil {
+		http.NotFound(w, r)
+		return nil, nil
+	}
+	return project, nil
+}
+
+// dashboardTemplate charts fuzzy match, matched code, and matched functions percentages
+// per version over time, fetching the underlying series from progress.json.
+const dashboardTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>%s/%s - decompal progress</title>
+  <script src="https://cdn.jsdelivr.net/npm/chart.js"></script>
+</head>
+<body>
+  <h1>%s/%s</h1>
+  <canvas id="chart"></canvas>
+  <script>
+    fetch("progress.json").then(r => r.json()).then(data => {
+      const datasets = [];
+      for (const [version, points] of Object.entries(data.versions)) {
+        for (const key of ["fuzzy_match_percent", "matched_code_percent", "matched_functions_percent"]) {
+          datasets.push({
+            label: version + " " + key,
+            data: points.map(p => ({x: p.timestamp, y: p.measures[key]})),
+          });
+        }
+      }
+      new Chart(document.getElementById("chart"), {
+        type: "line",
+        data: {datasets},
+        options: {parsing: false, scales: {x: {type: "time"}, y: {min: 0, max: 100}}},
+      });
+    });
+  </script>
+</body>
+</html>
+`
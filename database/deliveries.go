@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Delivery states persisted in pending_deliveries.
+const (
+	DeliveryStatePending = "pending"
+	DeliveryStateDone    = "done"
+	DeliveryStateFailed  = "failed"
+)
+
+// maxDeliveryAttempts bounds the retry/backoff policy: a delivery that keeps failing past
+// this many attempts is marked "failed" instead of retried forever.
+const maxDeliveryAttempts = 5
+
+// PendingDelivery is a webhook delivery that has not yet finished processing, used to
+// replay in-flight work after a restart.
+type PendingDelivery struct {
+	DeliveryID string
+	EventType  string
+	Payload    []byte
+	Attempts   int
+}
+
+// EnqueueDelivery records a webhook delivery as pending before its background task starts,
+// so the delivery isn't lost if the process is killed mid-run.
+func (d *DB) EnqueueDelivery(ctx context.Context, deliveryID string, eventType string, payload []byte) error {
+	now := time.Now()
+	_, err := d.ExecContext(
+		ctx,
+		`INSERT INTO pending_deliveries (delivery_id, event_type, payload, state, attempts, next_retry_at, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, 0, ?, ?, ?)
+		 ON CONFLICT(delivery_id) DO NOTHING`,
+		deliveryID, eventType, payload, DeliveryStatePending, now, now, now,
+	)
+	return err
+}
+
+// MarkDeliveryDone marks a delivery as successfully processed.
+func (d *DB) MarkDeliveryDone(ctx context.Context, deliveryID string) error {
+	_, err := d.ExecContext(
+		ctx,
+		`UPDATE pending_deliveries SET state = ?, updated_at = ? WHERE delivery_id = ?`,
+		DeliveryStateDone, time.Now(), deliveryID,
+	)
+	return err
+}
+
+// MarkDeliveryFailed records a failed attempt and schedules a retry with a linear backoff
+// (one minute per attempt so far), giving up and marking the delivery "failed" once
+// maxDeliveryAttempts is reached.
+func (d *DB) MarkDeliveryFailed(ctx context.Context, deliveryID string) error {
+	row := d.QueryRowContext(ctx, `SELECT attempts FROM pending_deliveries WHERE delivery_id = ?`, deliveryID)
+	var attempts int
+	if err := row.Scan(&attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	attempts++
+	state := DeliveryStatePending
+	if attempts >= maxDeliveryAttempts {
+		state = DeliveryStateFailed
+	}
+	nextRetryAt := time.Now().Add(time.Duration(attempts) * time.Minute)
+	_, err := d.ExecContext(
+		ctx,
+		`UPDATE pending_deliveries
+		 SET state = ?, attempts = ?, next_retry_at = ?, updated_at = ?
+		 WHERE delivery_id = ?`,
+		state, attempts, nextRetryAt, time.Now(), deliveryID,
+	)
+	return err
+}
+
+// ListPendingDeliveries returns deliveries still in the "pending" state whose next retry
+// time has passed, ordered oldest first.
+func (d *DB) ListPendingDeliveries(ctx context.Context) ([]PendingDelivery, error) {
+	rows, err := d.QueryContext(
+		ctx,
+		`SELECT delivery_id, event_type, payload, attempts
+		 FROM pending_deliveries
+		 WHERE state = ? AND next_retry_at <= ?
+		 ORDER BY created_at`,
+		DeliveryStatePending, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]PendingDelivery, 0)
+	for rows.Next() {
+		var pd PendingDelivery
+		if err := rows.Scan(&pd.DeliveryID, &pd.EventType, &pd.Payload, &pd.Attempts); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, pd)
+	}
+	return deliveries, rows.Err()
+}
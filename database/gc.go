@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// unitCacheHits and unitCacheInserts count, process-wide, how many times insertReportUnits
+// found an existing report_units row for a content hash versus had to insert a new one.
+// They back the dedup rate reported by GCStats.
+var (
+	unitCacheHits    atomic.Int64
+	unitCacheInserts atomic.Int64
+)
+
+// GCStats summarizes the outcome of a GCReportUnits pass, or its prediction for a dry run.
+type GCStats struct {
+	OrphanedUnits int64
+	CacheHits     int64
+	CacheInserts  int64
+}
+
+// GCReportUnits deletes report_units rows no longer referenced by any report_report_units
+// row. Units are content-addressed and shared across reports that happen to produce
+// identical functions, so a unit only becomes collectible once every report that
+// referenced it has been overwritten or deleted. With dryRun set, it reports how many
+// units would be deleted without deleting them.
+func (d *DB) GCReportUnits(ctx context.Context, dryRun bool) (GCStats, error) {
+	stats := GCStats{
+		CacheHits:    unitCacheHits.Load(),
+		CacheInserts: unitCacheInserts.Load(),
+	}
+
+	if dryRun {
+		row := d.QueryRowContext(
+			ctx,
+			`SELECT COUNT(*) FROM report_units ru
+			 WHERE NOT EXISTS (SELECT 1 FROM report_report_units rru WHERE rru.report_unit_id = ru.id)`,
+		)
+		if err := row.Scan(&stats.OrphanedUnits); err != nil {
+			return stats, err
+		}
+		return stats, nil
+	}
+
+	result, err := d.ExecContext(
+		ctx,
+		`DELETE FROM report_units
+		 WHERE NOT EXISTS (SELECT 1 FROM report_report_units rru WHERE rru.report_unit_id = report_units.id)`,
+	)
+	if err != nil {
+		return stats, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return stats, err
+	}
+	stats.OrphanedUnits = deleted
+	return stats, nil
+}
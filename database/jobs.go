@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Job states persisted in jobs.
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// maxJobAttempts bounds the retry/backoff policy: a job that keeps failing past this many
+// attempts is marked "failed" instead of retried forever.
+const maxJobAttempts = 8
+
+// Job is a unit of background work persisted so it survives a process restart, unlike a
+// bare goroutine.
+type Job struct {
+	ID       int64
+	Kind     string
+	Payload  []byte
+	Attempts int
+}
+
+// EnqueueJob records a new job of the given kind, to be picked up by a worker pool. It
+// becomes eligible for ClaimJob immediately.
+func (d *DB) EnqueueJob(ctx context.Context, kind string, payload []byte) (int64, error) {
+	now := time.Now()
+	result, err := d.ExecContext(
+		ctx,
+		`INSERT INTO jobs (kind, payload, status, attempts, next_run_at, created_at, updated_at)
+		 VALUES (?, ?, ?, 0, ?, ?, ?)`,
+		kind, payload, JobStatusPending, now, now, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// EnqueueJobForDelivery is EnqueueJob, but deduplicated on deliveryID: if a job for this
+// delivery was already enqueued (e.g. by Handle before a crash, then again by Replay after
+// RequeueStuckJobs reset it back to pending), the insert is a no-op instead of creating a
+// second job for the same webhook delivery.
+func (d *DB) EnqueueJobForDelivery(ctx context.Context, kind string, payload []byte, deliveryID string) (int64, error) {
+	now := time.Now()
+	result, err := d.ExecContext(
+		ctx,
+		`INSERT INTO jobs (kind, payload, status, attempts, next_run_at, created_at, updated_at, delivery_id)
+		 VALUES (?, ?, ?, 0, ?, ?, ?, ?)
+		 ON CONFLICT(delivery_id) WHERE delivery_id IS NOT NULL DO NOTHING`,
+		kind, payload, JobStatusPending, now, now, now, deliveryID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ClaimJob atomically claims the oldest due job, marking it "running" so other workers
+// don't also pick it up. It returns a nil Job if none are due.
+func (d *DB) ClaimJob(ctx context.Context) (*Job, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(
+		ctx,
+		`SELECT id, kind, payload, attempts FROM jobs
+		 WHERE status = ? AND next_run_at <= ?
+		 ORDER BY next_run_at
+		 LIMIT 1`,
+		JobStatusPending, time.Now(),
+	)
+	var job Job
+	if err := row.Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE jobs SET status = ?, updated_at = ? WHERE id = ?`,
+		JobStatusRunning, time.Now(), job.ID,
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// MarkJobDone marks a job as successfully processed.
+func (d *DB) MarkJobDone(ctx context.Context, id int64) error {
+	_, err := d.ExecContext(
+		ctx,
+		`UPDATE jobs SET status = ?, updated_at = ? WHERE id = ?`,
+		JobStatusDone, time.Now(), id,
+	)
+	return err
+}
+
+// MarkJobFailed records a failed attempt and schedules an exponential backoff retry,
+// giving up and marking the job "failed" once maxJobAttempts is reached.
+func (d *DB) MarkJobFailed(ctx context.Context, id int64, cause error) error {
+	row := d.QueryRowContext(ctx, `SELECT attempts FROM jobs WHERE id = ?`, id)
+	var attempts int
+	if err := row.Scan(&attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	attempts++
+	status := JobStatusPending
+	if attempts >= maxJobAttempts {
+		status = JobStatusFailed
+	}
+	const maxBackoff = 30 * time.Minute
+	backoff := time.Duration(1<<uint(attempts)) * time.Second
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	_, err := d.ExecContext(
+		ctx,
+		`UPDATE jobs
+		 SET status = ?, attempts = ?, next_run_at = ?, last_error = ?, updated_at = ?
+		 WHERE id = ?`,
+		status, attempts, time.Now().Add(backoff), cause.Error(), time.Now(), id,
+	)
+	return err
+}
+
+// RequeueStuckJobs resets any job left "running" by a previous process back to "pending",
+// so a crash mid-run doesn't strand it forever. Call once at startup before workers begin
+// claiming jobs.
+func (d *DB) RequeueStuckJobs(ctx context.Context) error {
+	_, err := d.ExecContext(
+		ctx,
+		`UPDATE jobs SET status = ?, updated_at = ? WHERE status = ?`,
+		JobStatusPending, time.Now(), JobStatusRunning,
+	)
+	return err
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/encounter/decompal/common"
 )
 
@@ -16,3 +17,19 @@ func UpsertProject(tx *sql.Tx, ctx context.Context, project *common.Project) err
 	)
 	return err
 }
+
+func (d *DB) GetProjectByName(ctx context.Context, owner string, name string) (*common.Project, error) {
+	row := d.QueryRowContext(
+		ctx,
+		`SELECT id FROM projects WHERE owner = ? AND name = ?`,
+		owner, name,
+	)
+	project := &common.Project{Owner: owner, Name: name}
+	if err := row.Scan(&project.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return project, nil
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/encounter/decompal/common"
+	"google.golang.org/protobuf/proto"
+	"time"
 )
 
 func (d *DB) InsertReport(ctx context.Context, file *common.ReportFile) error {
@@ -52,13 +54,25 @@ func (d *DB) InsertReport(ctx context.Context, file *common.ReportFile) error {
 	return tx.Commit()
 }
 
+// insertReportUnits inserts a content-addressed report unit, or does nothing if a unit
+// with the same hash already exists. Reports frequently re-produce identical functions
+// across builds, so most calls are expected to be cache hits; unitCacheHits/unitCacheInserts
+// track that rate for GCStats.
 func insertReportUnits(tx *sql.Tx, ctx context.Context, unit common.SerializedReportUnit) error {
-	_, err := tx.ExecContext(
+	result, err := tx.ExecContext(
 		ctx,
 		`INSERT INTO report_units (id, data) VALUES (?, ?) ON CONFLICT(id) DO NOTHING`,
 		unit.Key[:], unit.Data,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		unitCacheHits.Add(1)
+	} else {
+		unitCacheInserts.Add(1)
+	}
+	return nil
 }
 
 func (d *DB) ReportExists(ctx context.Context, projectID int64, version string, commitSha string) (bool, error) {
@@ -142,3 +156,66 @@ func (d *DB) GetReport(ctx context.Context, projectID int64, version string, com
 		Report:  report,
 	}, nil
 }
+
+func (d *DB) ListVersions(ctx context.Context, projectID int64) ([]string, error) {
+	rows, err := d.QueryContext(
+		ctx,
+		`SELECT DISTINCT version FROM reports WHERE project_id = ? ORDER BY version`,
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := make([]string, 0)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// ProgressPoint is a single sample of a project version's overall measures at a point in
+// time, used to chart progress.
+type ProgressPoint struct {
+	Timestamp time.Time
+	Commit    string
+	Measures  *common.Measures
+}
+
+// GetProgressSeries returns the overall measures for every stored report of a project's
+// version, ordered oldest to newest. It reads the aggregate measures directly out of the
+// sparse `reports.data` column rather than joining through report_units, since the
+// per-unit breakdown isn't needed for a progress chart.
+func (d *DB) GetProgressSeries(ctx context.Context, projectID int64, version string) ([]ProgressPoint, error) {
+	rows, err := d.QueryContext(
+		ctx,
+		`SELECT timestamp, git_commit, data
+			   FROM reports
+			   WHERE project_id = ? AND version = ?
+			   ORDER BY timestamp`,
+		projectID, version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	points := make([]ProgressPoint, 0)
+	for rows.Next() {
+		var timestamp time.Time
+		var gitCommit string
+		var data []byte
+		if err := rows.Scan(&timestamp, &gitCommit, &data); err != nil {
+			return nil, err
+		}
+		sparse := &common.Report{}
+		if err := proto.Unmarshal(data, sparse); err != nil {
+			return nil, err
+		}
+		points = append(points, ProgressPoint{Timestamp: timestamp, Commit: gitCommit, Measures: sparse.Measures})
+	}
+	return points, rows.Err()
+}
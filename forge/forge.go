@@ -0,0 +1,66 @@
+// Package forge abstracts the parts of decompal that talk to a code forge (GitHub,
+// GitLab, Gitea, ...) behind a single interface, so the rest of the app doesn't need to
+// know which one a given project is hosted on.
+package forge
+
+import (
+	"context"
+	"github.com/encounter/decompal/common"
+	"io"
+)
+
+// BotAuthor identifies comments created by decompal, so they can be found again and
+// updated in place rather than duplicated. Check runs are found again by name instead.
+const BotAuthor = "decompal[bot]"
+
+// WorkflowRun is a forge-agnostic view of a completed CI run (a GitHub Actions workflow
+// run, a GitLab pipeline, etc.) that may have produced report artifacts.
+type WorkflowRun struct {
+	ID         int64
+	WorkflowID int64
+	HeadSHA    string
+}
+
+// Artifact references a single build artifact attached to a WorkflowRun.
+type Artifact struct {
+	ID   int64
+	Name string
+}
+
+// IssueComment is a forge-agnostic view of a comment on a pull/merge request.
+type IssueComment struct {
+	ID     int64
+	Author string
+	Body   string
+}
+
+// CheckAnnotation pins a message to a location in a check, analogous to GitHub's Check
+// Run annotations.
+type CheckAnnotation struct {
+	Path    string
+	Line    int
+	Level   string // "notice", "warning", or "failure"
+	Title   string
+	Message string
+}
+
+// CheckRun is a forge-agnostic summary of a CI check to publish against a commit.
+type CheckRun struct {
+	Name        string
+	HeadSHA     string
+	Conclusion  string // "success", "failure", or "neutral"
+	Summary     string
+	Annotations []CheckAnnotation
+}
+
+// Client is implemented once per forge so the rest of decompal can fetch commits and
+// artifacts, and publish comments/checks, without depending on a specific forge's SDK.
+type Client interface {
+	GetCommit(ctx context.Context, project *common.Project, sha string) (*common.Commit, error)
+	ListCompletedWorkflowRunsForSHA(ctx context.Context, project *common.Project, sha string) ([]WorkflowRun, error)
+	ListWorkflowRunArtifacts(ctx context.Context, project *common.Project, run WorkflowRun) ([]Artifact, error)
+	DownloadArtifact(ctx context.Context, project *common.Project, artifact Artifact) (io.ReadCloser, error)
+	ListIssueComments(ctx context.Context, project *common.Project, number int) ([]IssueComment, error)
+	UpsertIssueComment(ctx context.Context, project *common.Project, number int, author string, body string) error
+	CreateCheckRun(ctx context.Context, project *common.Project, run CheckRun) error
+}
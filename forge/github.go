@@ -0,0 +1,261 @@
+package forge
+
+import (
+	"context"
+	"github.com/encounter/decompal/common"
+	"github.com/google/go-github/v63/github"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+)
+
+// maxAnnotationsPerRequest is the GitHub Checks API limit on annotations per call.
+const maxAnnotationsPerRequest = 50
+
+// GitHubClient implements Client against the GitHub REST API.
+type GitHubClient struct {
+	client *github.Client
+}
+
+func NewGitHubClient(client *github.Client) *GitHubClient {
+	return &GitHubClient{client: client}
+}
+
+func (c *GitHubClient) GetCommit(ctx context.Context, project *common.Project, sha string) (*common.Commit, error) {
+	ghc, _, err := c.client.Git.GetCommit(ctx, project.Owner, project.Name, sha)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get commit")
+	}
+	return &common.Commit{
+		Sha:       ghc.GetSHA(),
+		Timestamp: ghc.GetCommitter().GetDate().Time,
+	}, nil
+}
+
+func (c *GitHubClient) ListCompletedWorkflowRunsForSHA(
+	ctx context.Context,
+	project *common.Project,
+	sha string,
+) ([]WorkflowRun, error) {
+	runs, _, err := c.client.Actions.ListRepositoryWorkflowRuns(
+		ctx,
+		project.Owner,
+		project.Name,
+		&github.ListWorkflowRunsOptions{
+			Status:              "completed",
+			HeadSHA:             sha,
+			ExcludePullRequests: true,
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list workflow runs")
+	}
+	result := make([]WorkflowRun, 0, len(runs.WorkflowRuns))
+	for _, run := range runs.WorkflowRuns {
+		result = append(result, WorkflowRun{
+			ID:         run.GetID(),
+			WorkflowID: run.GetWorkflowID(),
+			HeadSHA:    run.GetHeadSHA(),
+		})
+	}
+	return result, nil
+}
+
+func (c *GitHubClient) ListWorkflowRunArtifacts(
+	ctx context.Context,
+	project *common.Project,
+	run WorkflowRun,
+) ([]Artifact, error) {
+	artifacts, _, err := c.client.Actions.ListWorkflowRunArtifacts(ctx, project.Owner, project.Name, run.ID, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list workflow run artifacts")
+	}
+	result := make([]Artifact, 0, len(artifacts.Artifacts))
+	for _, artifact := range artifacts.Artifacts {
+		result = append(result, Artifact{ID: artifact.GetID(), Name: artifact.GetName()})
+	}
+	return result, nil
+}
+
+func (c *GitHubClient) DownloadArtifact(
+	ctx context.Context,
+	project *common.Project,
+	artifact Artifact,
+) (io.ReadCloser, error) {
+	artifactUrl, _, err := c.client.Actions.DownloadArtifact(ctx, project.Owner, project.Name, artifact.ID, 3)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get artifact download url")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, artifactUrl.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create download request")
+	}
+	req.Header.Set("User-Agent", c.client.UserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download artifact")
+	}
+	return resp.Body, nil
+}
+
+func (c *GitHubClient) ListIssueComments(
+	ctx context.Context,
+	project *common.Project,
+	number int,
+) ([]IssueComment, error) {
+	sort := "created"
+	direction := "asc"
+	existing, _, err := c.client.Issues.ListComments(ctx, project.Owner, project.Name, number, &github.IssueListCommentsOptions{
+		Sort:      &sort,
+		Direction: &direction,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list existing comments")
+	}
+	result := make([]IssueComment, 0, len(existing))
+	for _, comment := range existing {
+		result = append(result, IssueComment{
+			ID:     comment.GetID(),
+			Author: comment.GetUser().GetLogin(),
+			Body:   comment.GetBody(),
+		})
+	}
+	return result, nil
+}
+
+func (c *GitHubClient) UpsertIssueComment(
+	ctx context.Context,
+	project *common.Project,
+	number int,
+	author string,
+	body string,
+) error {
+	existing, err := c.ListIssueComments(ctx, project, number)
+	if err != nil {
+		return err
+	}
+	for _, comment := range existing {
+		// TODO: update go-github to expose performed_via_github_app
+		if comment.Author != author {
+			continue
+		}
+		if comment.Body == body {
+			// No changes
+			return nil
+		}
+		_, _, err = c.client.Issues.EditComment(ctx, project.Owner, project.Name, comment.ID, &github.IssueComment{
+			Body: github.String(body),
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to edit comment")
+		}
+		return nil
+	}
+	_, _, err = c.client.Issues.CreateComment(ctx, project.Owner, project.Name, number, &github.IssueComment{
+		Body: github.String(body),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create comment")
+	}
+	return nil
+}
+
+func (c *GitHubClient) CreateCheckRun(ctx context.Context, project *common.Project, run CheckRun) error {
+	annotations := make([]*github.CheckRunAnnotation, len(run.Annotations))
+	for i, a := range run.Annotations {
+		annotations[i] = &github.CheckRunAnnotation{
+			Path:            github.String(a.Path),
+			StartLine:       github.Int(a.Line),
+			EndLine:         github.Int(a.Line),
+			AnnotationLevel: github.String(a.Level),
+			Title:           github.String(a.Title),
+			Message:         github.String(a.Message),
+		}
+	}
+
+	existingID, err := c.findCheckRunID(ctx, project, run.HeadSHA, run.Name)
+	if err != nil {
+		return err
+	}
+
+	first := annotations
+	if len(first) > maxAnnotationsPerRequest {
+		first = first[:maxAnnotationsPerRequest]
+	}
+	output := &github.CheckRunOutput{
+		Title:       github.String(run.Name),
+		Summary:     github.String(run.Summary),
+		Annotations: first,
+	}
+
+	var checkRunID int64
+	if existingID != 0 {
+		checkRunID = existingID
+		_, _, err = c.client.Checks.UpdateCheckRun(ctx, project.Owner, project.Name, checkRunID, github.UpdateCheckRunOptions{
+			Name:       run.Name,
+			HeadSHA:    github.String(run.HeadSHA),
+			Status:     github.String("completed"),
+			Conclusion: github.String(run.Conclusion),
+			Output:     output,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to update check run")
+		}
+	} else {
+		created, _, err := c.client.Checks.CreateCheckRun(ctx, project.Owner, project.Name, github.CreateCheckRunOptions{
+			Name:       run.Name,
+			HeadSHA:    run.HeadSHA,
+			Status:     github.String("completed"),
+			Conclusion: github.String(run.Conclusion),
+			Output:     output,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to create check run")
+		}
+		checkRunID = created.GetID()
+	}
+
+	// The Checks API only accepts maxAnnotationsPerRequest annotations per call, so flush
+	// the rest in follow-up updates.
+	for _, batch := range batchAnnotations(annotations[len(first):], maxAnnotationsPerRequest) {
+		_, _, err = c.client.Checks.UpdateCheckRun(ctx, project.Owner, project.Name, checkRunID, github.UpdateCheckRunOptions{
+			Name: run.Name,
+			Output: &github.CheckRunOutput{
+				Title:       github.String(run.Name),
+				Summary:     github.String(run.Summary),
+				Annotations: batch,
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to append check run annotations")
+		}
+	}
+	return nil
+}
+
+func (c *GitHubClient) findCheckRunID(ctx context.Context, project *common.Project, ref string, name string) (int64, error) {
+	result, _, err := c.client.Checks.ListCheckRunsForRef(ctx, project.Owner, project.Name, ref, &github.ListCheckRunsOptions{
+		CheckName: &name,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list existing check runs")
+	}
+	if len(result.CheckRuns) == 0 {
+		return 0, nil
+	}
+	return result.CheckRuns[0].GetID(), nil
+}
+
+func batchAnnotations(annotations []*github.CheckRunAnnotation, size int) [][]*github.CheckRunAnnotation {
+	var batches [][]*github.CheckRunAnnotation
+	for len(annotations) > 0 {
+		n := size
+		if n > len(annotations) {
+			n = len(annotations)
+		}
+		batches = append(batches, annotations[:n])
+		annotations = annotations[n:]
+	}
+	return batches
+}
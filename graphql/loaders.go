@@ -0,0 +1,54 @@
+package graphql
+
+import (
+	"context"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/database"
+	"sync"
+)
+
+// reportKey identifies a single stored report, the unit of work a ReportLoader batches on.
+type reportKey struct {
+	projectID int64
+	version   string
+	commit    string
+}
+
+// ReportLoader deduplicates report_units lookups within a single GraphQL request. A query
+// like `diff(base, head)` or several `functions(changedSince: ...)` fields can reference the
+// same report from multiple resolver branches; without this, each reference would re-run the
+// same report_units join.
+type ReportLoader struct {
+	db    *database.DB
+	mu    sync.Mutex
+	cache map[reportKey]*common.ReportFile
+}
+
+// NewReportLoader creates a ReportLoader backed by db. A fresh loader should be created per
+// request so its cache doesn't grow unbounded or serve stale data across requests.
+func NewReportLoader(db *database.DB) *ReportLoader {
+	return &ReportLoader{db: db, cache: make(map[reportKey]*common.ReportFile)}
+}
+
+// Load fetches the report for projectID/version/commit, serving a cached copy if this
+// loader has already fetched it during the current request.
+func (l *ReportLoader) Load(ctx context.Context, projectID int64, version string, commit string) (*common.ReportFile, error) {
+	key := reportKey{projectID, version, commit}
+
+	l.mu.Lock()
+	if report, ok := l.cache[key]; ok {
+		l.mu.Unlock()
+		return report, nil
+	}
+	l.mu.Unlock()
+
+	report, err := l.db.GetReport(ctx, projectID, version, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.cache[key] = report
+	l.mu.Unlock()
+	return report, nil
+}
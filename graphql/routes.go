@@ -0,0 +1,37 @@
+package graphql
+
+import (
+	"github.com/encounter/decompal/config"
+	"github.com/encounter/decompal/database"
+	gqlhandler "github.com/graphql-go/handler"
+	"goji.io"
+	"goji.io/pat"
+	"net/http"
+)
+
+// RegisterRoutes mounts the GraphQL API, and its GraphiQL explorer, at /graphql.
+func RegisterRoutes(mux *goji.Mux, db *database.DB, cfg *config.AppConfig) error {
+	schema, err := NewSchema()
+	if err != nil {
+		return err
+	}
+
+	h := gqlhandler.New(&gqlhandler.Config{
+		Schema:     &schema,
+		Pretty:     true,
+		GraphiQL:   true,
+		Playground: false,
+	})
+
+	withEnv := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			loader := NewReportLoader(db)
+			ctx := WithEnv(r.Context(), db, cfg, loader)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+
+	mux.Handle(pat.Get("/graphql"), withEnv(h))
+	mux.Handle(pat.Post("/graphql"), withEnv(h))
+	return nil
+}
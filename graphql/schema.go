@@ -0,0 +1,385 @@
+// Package graphql exposes a read-only GraphQL schema over database.DB's stored objdiff
+// reports, so tooling can query progress directly instead of scraping PR comments.
+package graphql
+
+import (
+	"context"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/config"
+	"github.com/encounter/decompal/database"
+	"github.com/encounter/decompal/objdiff"
+	"github.com/graphql-go/graphql"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"strings"
+)
+
+// resolvedUnit pairs a report unit with the report it came from, since unit.functions needs
+// its parent report's project/version to diff against another commit.
+type resolvedUnit struct {
+	report *common.ReportFile
+	unit   *common.ReportUnit
+}
+
+var measuresType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Measures",
+	Fields: graphql.Fields{
+		"fuzzyMatchPercent": &graphql.Field{
+			Type: graphql.Float,
+			Resolve: measureResolver(func(m *common.Measures) float32 {
+				return m.GetFuzzyMatchPercent()
+			}),
+		},
+		"matchedCodePercent": &graphql.Field{
+			Type: graphql.Float,
+			Resolve: measureResolver(func(m *common.Measures) float32 {
+				return m.GetMatchedCodePercent()
+			}),
+		},
+		"matchedDataPercent": &graphql.Field{
+			Type: graphql.Float,
+			Resolve: measureResolver(func(m *common.Measures) float32 {
+				return m.GetMatchedDataPercent()
+			}),
+		},
+		"matchedFunctionsPercent": &graphql.Field{
+			Type: graphql.Float,
+			Resolve: measureResolver(func(m *common.Measures) float32 {
+				return m.GetMatchedFunctionsPercent()
+			}),
+		},
+	},
+})
+
+func measureResolver(get func(*common.Measures) float32) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		measures, _ := p.Source.(*common.Measures)
+		if measures == nil {
+			return nil, nil
+		}
+		return get(measures), nil
+	}
+}
+
+var reportItemType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Function",
+	Fields: graphql.Fields{
+		"name": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				item, _ := p.Source.(*common.ReportItem)
+				return item.GetName(), nil
+			},
+		},
+		"fuzzyMatchPercent": &graphql.Field{
+			Type: graphql.Float,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				item, _ := p.Source.(*common.ReportItem)
+				return item.GetFuzzyMatchPercent(), nil
+			},
+		},
+	},
+})
+
+var reportUnitType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ReportUnit",
+	Fields: graphql.Fields{
+		"name": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				ru, _ := p.Source.(*resolvedUnit)
+				return ru.unit.GetName(), nil
+			},
+		},
+		"measures": &graphql.Field{
+			Type: measuresType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				ru, _ := p.Source.(*resolvedUnit)
+				return ru.unit.GetMeasures(), nil
+			},
+		},
+		"functions": &graphql.Field{
+			Type: graphql.NewList(reportItemType),
+			Args: graphql.FieldConfigArgument{
+				"changedSince": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: resolveUnitFunctions,
+		},
+	},
+})
+
+// resolveUnitFunctions returns a unit's functions. With changedSince set to another commit
+// SHA, it instead diffs the unit's report against that commit and returns only the
+// functions whose fuzzy_match_percent changed, e.g. to spot regressions over recent commits.
+func resolveUnitFunctions(p graphql.ResolveParams) (interface{}, error) {
+	ru, _ := p.Source.(*resolvedUnit)
+	if ru == nil {
+		return nil, nil
+	}
+	changedSince, _ := p.Args["changedSince"].(string)
+	if changedSince == "" {
+		return ru.unit.GetFunctions(), nil
+	}
+
+	env := envFromContext(p.Context)
+	if env == nil {
+		return nil, errors.New("graphql environment not attached to context")
+	}
+	baseline, err := env.loader.Load(p.Context, ru.report.Project.ID, ru.report.Version, changedSince)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load baseline report")
+	}
+	if baseline == nil {
+		return nil, nil
+	}
+
+	changes, err := objdiff.GenerateChanges(env.config, zerolog.Ctx(p.Context).With().Logger(), baseline, ru.report)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate changes")
+	}
+	return changedFunctionsInUnit(ru.unit.GetName(), changes), nil
+}
+
+// changedFunctionsInUnit returns the functions belonging to unitName whose match percentage
+// differs between the "from" and "to" side of changes.
+func changedFunctionsInUnit(unitName string, changes *common.Changes) []*common.ReportItem {
+	items := make([]*common.ReportItem, 0)
+	for _, unitChange := range changes.GetUnits() {
+		if unitChange.GetName() != unitName {
+			continue
+		}
+		for _, funcChange := range unitChange.GetFunctions() {
+			if funcChange.GetFrom().GetFuzzyMatchPercent() != funcChange.GetTo().GetFuzzyMatchPercent() {
+				items = append(items, funcChange.GetTo())
+			}
+		}
+	}
+	return items
+}
+
+var reportType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Report",
+	Fields: graphql.Fields{
+		"version": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				report, _ := p.Source.(*common.ReportFile)
+				return report.Version, nil
+			},
+		},
+		"commit": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				report, _ := p.Source.(*common.ReportFile)
+				return report.Commit.Sha, nil
+			},
+		},
+		"timestamp": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				report, _ := p.Source.(*common.ReportFile)
+				return report.Commit.Timestamp.UTC().Format("2006-01-02T15:04:05Z"), nil
+			},
+		},
+		"measures": &graphql.Field{
+			Type: measuresType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				report, _ := p.Source.(*common.ReportFile)
+				return report.Report.Measures, nil
+			},
+		},
+		"units": &graphql.Field{
+			Type: graphql.NewList(reportUnitType),
+			Args: graphql.FieldConfigArgument{
+				"filter": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: resolveReportUnits,
+		},
+	},
+})
+
+// resolveReportUnits filters a report's units by a case-insensitive substring match against
+// the unit name, e.g. `units(filter: "d_a_player")`.
+func resolveReportUnits(p graphql.ResolveParams) (interface{}, error) {
+	report, _ := p.Source.(*common.ReportFile)
+	if report == nil {
+		return nil, nil
+	}
+	filter, _ := p.Args["filter"].(string)
+	filter = strings.ToLower(filter)
+
+	units := make([]*resolvedUnit, 0, len(report.Report.Units))
+	for _, unit := range report.Report.Units {
+		if filter == "" || strings.Contains(strings.ToLower(unit.GetName()), filter) {
+			units = append(units, &resolvedUnit{report: report, unit: unit})
+		}
+	}
+	return units, nil
+}
+
+var projectType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Project",
+	Fields: graphql.Fields{
+		"owner": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				project, _ := p.Source.(*common.Project)
+				return project.Owner, nil
+			},
+		},
+		"name": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				project, _ := p.Source.(*common.Project)
+				return project.Name, nil
+			},
+		},
+		"commits": &graphql.Field{
+			Type: graphql.NewList(reportType),
+			Args: graphql.FieldConfigArgument{
+				"version": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: resolveProjectCommits,
+		},
+	},
+})
+
+// resolveProjectCommits returns every stored report for a project's version, ordered oldest
+// to newest. The `branch`/`since`/`until` filters described for this field would need a
+// branch column reports doesn't have yet, so for now every stored report is returned.
+// Each returned report carries only its overall measures, read from the progress series;
+// its units list is empty.
+func resolveProjectCommits(p graphql.ResolveParams) (interface{}, error) {
+	env := envFromContext(p.Context)
+	if env == nil {
+		return nil, errors.New("graphql environment not attached to context")
+	}
+	project, _ := p.Source.(*common.Project)
+	version, _ := p.Args["version"].(string)
+
+	points, err := env.db.GetProgressSeries(p.Context, project.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*common.ReportFile, 0, len(points))
+	for _, point := range points {
+		reports = append(reports, &common.ReportFile{
+			Project: project,
+			Version: version,
+			Commit:  &common.Commit{Sha: point.Commit, Timestamp: point.Timestamp},
+			Report:  &common.Report{Measures: point.Measures},
+		})
+	}
+	return reports, nil
+}
+
+var changesType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Changes",
+	Fields: graphql.Fields{
+		"units": &graphql.Field{
+			Type: graphql.NewList(reportUnitType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				changes, _ := p.Source.(*common.Changes)
+				return changes.GetUnits(), nil
+			},
+		},
+	},
+})
+
+// env bundles the dependencies resolvers need. graphql-go builds a schema once at startup,
+// so per-request state (the database handle is shared, but the ReportLoader isn't) is
+// threaded through via the query context instead of closed over directly.
+type env struct {
+	db     *database.DB
+	config *config.AppConfig
+	loader *ReportLoader
+}
+
+type envContextKey struct{}
+
+// WithEnv attaches db, cfg, and loader to ctx for the lifetime of a single GraphQL request.
+func WithEnv(ctx context.Context, db *database.DB, cfg *config.AppConfig, loader *ReportLoader) context.Context {
+	return context.WithValue(ctx, envContextKey{}, &env{db: db, config: cfg, loader: loader})
+}
+
+func envFromContext(ctx context.Context) *env {
+	e, _ := ctx.Value(envContextKey{}).(*env)
+	return e
+}
+
+// NewSchema builds the read-only GraphQL schema served at /graphql.
+func NewSchema() (graphql.Schema, error) {
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"project": &graphql.Field{
+				Type: projectType,
+				Args: graphql.FieldConfigArgument{
+					"owner": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"name":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					env := envFromContext(p.Context)
+					if env == nil {
+						return nil, errors.New("graphql environment not attached to context")
+					}
+					owner, _ := p.Args["owner"].(string)
+					name, _ := p.Args["name"].(string)
+					return env.db.GetProjectByName(p.Context, owner, name)
+				},
+			},
+			"diff": &graphql.Field{
+				Type: changesType,
+				Args: graphql.FieldConfigArgument{
+					"owner":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"name":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"version": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"base":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"head":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: resolveDiff,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query: queryType,
+	})
+}
+
+// resolveDiff computes the Changes between two commits of a project's version on demand by
+// reusing objdiff.GenerateChanges, the same code path the webhook handlers use to build PR
+// comments.
+func resolveDiff(p graphql.ResolveParams) (interface{}, error) {
+	env := envFromContext(p.Context)
+	if env == nil {
+		return nil, errors.New("graphql environment not attached to context")
+	}
+	owner, _ := p.Args["owner"].(string)
+	name, _ := p.Args["name"].(string)
+	version, _ := p.Args["version"].(string)
+	base, _ := p.Args["base"].(string)
+	head, _ := p.Args["head"].(string)
+
+	project, err := env.db.GetProjectByName(p.Context, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, errors.Errorf("unknown project %s/%s", owner, name)
+	}
+
+	baseReport, err := env.loader.Load(p.Context, project.ID, version, base)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load base report")
+	}
+	headReport, err := env.loader.Load(p.Context, project.ID, version, head)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load head report")
+	}
+	if baseReport == nil || headReport == nil {
+		return nil, nil
+	}
+
+	return objdiff.GenerateChanges(env.config, zerolog.Ctx(p.Context).With().Logger(), baseReport, headReport)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/encounter/decompal/checks"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/config"
+	"github.com/encounter/decompal/forge"
+)
+
+const (
+	reportModeComment = "comment"
+	reportModeCheck   = "check"
+	reportModeBoth    = "both"
+)
+
+const checkRunName = "decompal"
+
+// upsertCheckRun publishes (or updates) a check run for a single version's changes, with
+// the overall measures as the summary, one annotation per function whose match percentage
+// changed, and a conclusion driven by cfg.RegressionFailThreshold.
+func upsertCheckRun(
+	ctx context.Context,
+	client forge.Client,
+	cfg *config.AppConfig,
+	project *common.Project,
+	headSha string,
+	version string,
+	changes *common.Changes,
+) error {
+	summary := checks.Summary(changes)
+	if summary == "" {
+		summary = "No changes"
+	}
+	return client.CreateCheckRun(ctx, project, forge.CheckRun{
+		Name:        fmt.Sprintf("%s / %s", checkRunName, version),
+		HeadSHA:     headSha,
+		Conclusion:  checks.Conclusion(changes, cfg.RegressionFailThreshold),
+		Summary:     summary,
+		Annotations: checks.Annotations(changes),
+	})
+}
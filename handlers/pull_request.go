@@ -3,20 +3,47 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"github.com/encounter/decompal/broker"
 	"github.com/encounter/decompal/common"
 	"github.com/encounter/decompal/config"
 	"github.com/encounter/decompal/database"
+	"github.com/encounter/decompal/forge"
+	"github.com/encounter/decompal/jobqueue"
 	"github.com/encounter/decompal/objdiff"
 	"github.com/google/go-github/v63/github"
 	"github.com/palantir/go-githubapp/githubapp"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"time"
 )
 
+// jobKindProcessPullRequest identifies the jobqueue job that fetches report files and
+// updates a pull request for an opened/synchronize/reopened/edited pull_request event.
+const jobKindProcessPullRequest = "process_pull_request"
+
+// defaultPullRequestJobTimeout bounds a single process_pull_request job. Replacing the old
+// fixed 1-minute goroutine deadline, this is generous enough for waitForCompletedWorkflowRuns
+// to actually reach its own documented poll timeout, and a job that still fails is retried
+// with backoff instead of being dropped.
+const defaultPullRequestJobTimeout = 10 * time.Minute
+
+// pullRequestJobPayload is the jobqueue payload for jobKindProcessPullRequest. It wraps the
+// original webhook payload rather than any parsed event, so replaying a pending delivery and
+// processing its job both parse the event the same way.
+type pullRequestJobPayload struct {
+	DeliveryID     string `json:"delivery_id"`
+	InstallationID int64  `json:"installation_id"`
+	Payload        []byte `json:"payload"`
+}
+
 type pullRequestHandler struct {
 	githubapp.ClientCreator
 	config  *config.AppConfig
 	db      *database.DB
 	taskCtx context.Context
+	tasks   *TaskRunner
+	broker  broker.Publisher
+	jobs    *jobqueue.Queue
 }
 
 func NewPullRequestHandler(
@@ -24,116 +51,163 @@ func NewPullRequestHandler(
 	config *config.AppConfig,
 	db *database.DB,
 	taskCtx context.Context,
+	tasks *TaskRunner,
+	pub broker.Publisher,
+	jobs *jobqueue.Queue,
 ) githubapp.EventHandler {
-	return &pullRequestHandler{
+	h := &pullRequestHandler{
 		ClientCreator: cc,
 		config:        config,
 		db:            db,
 		taskCtx:       taskCtx,
+		tasks:         tasks,
+		broker:        pub,
+		jobs:          jobs,
 	}
+	jobs.Register(jobKindProcessPullRequest, defaultPullRequestJobTimeout, h.runJob)
+	return h
 }
 
 func (h *pullRequestHandler) Handles() []string {
 	return []string{"pull_request"}
 }
 
-func (h *pullRequestHandler) Handle(_ context.Context, eventType, deliveryID string, payload []byte) error {
+func (h *pullRequestHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
 	event := &github.PullRequestEvent{}
 	if err := json.Unmarshal(payload, event); err != nil {
 		return errors.Wrap(err, "failed to parse pull request event payload")
 	}
-	if event.GetAction() != "opened" {
+	switch event.GetAction() {
+	case "opened", "synchronize", "reopened", "edited":
+	default:
 		return nil
 	}
 
 	installationID := githubapp.GetInstallationIDFromEvent(event)
-	client, err := h.NewInstallationClient(installationID)
+	if err := h.tasks.Enqueue(h.taskCtx, deliveryID, eventType, payload); err != nil {
+		return err
+	}
+	return h.enqueueJob(h.taskCtx, deliveryID, installationID, payload)
+}
+
+// enqueueJob persists a process_pull_request job wrapping the raw webhook payload, so
+// ingestion survives a restart and a failed attempt is retried with backoff instead of
+// being killed at a fixed deadline. It's deduplicated on deliveryID so that Replay
+// re-processing a delivery RequeueStuckJobs already reset to pending doesn't enqueue a
+// second job for it.
+func (h *pullRequestHandler) enqueueJob(ctx context.Context, deliveryID string, installationID int64, payload []byte) error {
+	data, err := json.Marshal(pullRequestJobPayload{
+		DeliveryID:     deliveryID,
+		InstallationID: installationID,
+		Payload:        payload,
+	})
 	if err != nil {
 		return err
 	}
+	return h.jobs.EnqueueForDelivery(ctx, jobKindProcessPullRequest, data, deliveryID)
+}
 
-	go backgroundTask(h.taskCtx, eventType, deliveryID, func(ctx context.Context) error {
-		repo := event.GetRepo()
-		// Re-prepare logger instead of using the one from the request context
-		ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+// runJob is the jobqueue handler for jobKindProcessPullRequest. It re-parses the wrapped
+// webhook payload and dispatches to the same logic used to run inline, then records the
+// delivery's outcome for Replay.
+func (h *pullRequestHandler) runJob(ctx context.Context, data []byte) error {
+	var p pullRequestJobPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
 
-		// Find any completed workflow runs for the current PR
-		project := &common.Project{
-			ID:    repo.GetID(),
-			Owner: repo.GetOwner().GetLogin(),
-			Name:  repo.GetName(),
-		}
-		pr := event.GetPullRequest()
-		ghc, _, err := client.Git.GetCommit(ctx, project.Owner, project.Name, pr.GetHead().GetSHA())
-		if err != nil {
-			return errors.Wrap(err, "failed to get commit")
-		}
-		commit := &common.Commit{
-			Sha:       ghc.GetSHA(),
-			Timestamp: ghc.GetCommitter().GetDate().Time,
-		}
-		runs, _, err := client.Actions.ListRepositoryWorkflowRuns(
-			ctx,
-			project.Owner,
-			project.Name,
-			&github.ListWorkflowRunsOptions{
-				Status:              "completed",
-				HeadSHA:             commit.Sha,
-				ExcludePullRequests: true,
-			},
-		)
-		if err != nil {
-			return errors.Wrap(err, "failed to list workflow runs")
-		}
-		if len(runs.WorkflowRuns) == 0 {
-			logger.Debug().Msg("No workflow runs found")
-			return nil
-		}
+	err := h.processPullRequestEvent(ctx, p.InstallationID, p.Payload)
 
-		// Find report files in any completed workflow runs
-		var files []common.ReportFile
-		var run *github.WorkflowRun
-		for _, run = range runs.WorkflowRuns {
-			files, err = objdiff.FetchReportFiles(
-				ctx,
-				h.db,
-				logger,
-				client,
-				project,
-				commit,
-				run.GetID(),
-			)
-			if err != nil {
-				return err
-			}
-			if len(files) > 0 {
-				break
-			}
-		}
-		if run == nil || len(files) == 0 {
-			logger.Info().Msg("No report files found")
-			return nil
+	logger := zerolog.Ctx(ctx)
+	if err != nil {
+		if markErr := h.db.MarkDeliveryFailed(ctx, p.DeliveryID); markErr != nil {
+			logger.Error().Err(markErr).Msg("Failed to record delivery failure")
 		}
+		return err
+	}
+	if markErr := h.db.MarkDeliveryDone(ctx, p.DeliveryID); markErr != nil {
+		logger.Error().Err(markErr).Msg("Failed to record delivery completion")
+	}
+	return nil
+}
 
-		// Generate changes and create a PR comment
-		err = processPR(
+func (h *pullRequestHandler) processPullRequestEvent(ctx context.Context, installationID int64, payload []byte) error {
+	event := &github.PullRequestEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return errors.Wrap(err, "failed to parse pull request event payload")
+	}
+
+	repo := event.GetRepo()
+	// Re-prepare logger instead of using the one from the request context
+	ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+
+	ghClient, err := h.NewInstallationClient(installationID)
+	if err != nil {
+		return err
+	}
+	client := forge.NewGitHubClient(ghClient)
+
+	// Find any completed workflow runs for the current PR
+	project := &common.Project{
+		ID:    repo.GetID(),
+		Owner: repo.GetOwner().GetLogin(),
+		Name:  repo.GetName(),
+	}
+	pr := event.GetPullRequest()
+	commit, err := client.GetCommit(ctx, project, pr.GetHead().GetSHA())
+	if err != nil {
+		return err
+	}
+	// The webhook for a new head commit often arrives before that commit's CI has
+	// finished, so poll for completed workflow runs instead of checking once.
+	runs, err := waitForCompletedWorkflowRuns(ctx, client, h.config.WorkflowPollTimeout, project, commit.Sha)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		logger.Debug().Msg("No workflow runs found")
+		return nil
+	}
+
+	// Find report files in any completed workflow runs
+	var files []common.ReportFile
+	var run forge.WorkflowRun
+	for _, run = range runs {
+		files, err = objdiff.FetchReportFiles(
 			ctx,
 			h.db,
+			logger,
 			h.config,
-			installationID,
-			pr,
-			commit,
+			h.broker,
 			client,
-			repo,
-			run.GetWorkflowID(),
-			files,
+			project,
+			commit,
+			run.ID,
 		)
 		if err != nil {
 			return err
 		}
-
+		if len(files) > 0 {
+			break
+		}
+	}
+	if len(files) == 0 {
+		logger.Info().Msg("No report files found")
 		return nil
-	})
+	}
 
-	return nil
+	// Generate changes and create a PR comment
+	return processPR(
+		ctx,
+		h.db,
+		h.config,
+		h.broker,
+		installationID,
+		pr,
+		commit,
+		client,
+		repo,
+		run.WorkflowID,
+		files,
+	)
 }
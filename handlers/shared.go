@@ -3,24 +3,26 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"github.com/encounter/decompal/broker"
+	"github.com/encounter/decompal/checks"
 	"github.com/encounter/decompal/common"
 	"github.com/encounter/decompal/config"
 	"github.com/encounter/decompal/database"
+	"github.com/encounter/decompal/forge"
 	"github.com/encounter/decompal/objdiff"
 	"github.com/google/go-github/v63/github"
 	"github.com/palantir/go-githubapp/githubapp"
-	"github.com/pkg/errors"
-	"strings"
 )
 
 func processPR(
 	ctx context.Context,
 	db *database.DB,
 	config *config.AppConfig,
+	pub broker.Publisher,
 	installationID int64,
 	pr *github.PullRequest,
 	headCommit *common.Commit,
-	client *github.Client,
+	client forge.Client,
 	repo *github.Repository,
 	workflowID int64,
 	files []common.ReportFile,
@@ -45,29 +47,16 @@ func processPR(
 		Name:  repo.GetName(),
 	}
 	base := pr.GetBase()
-	ghc, _, err := client.Git.GetCommit(ctx, project.Owner, project.Name, base.GetSHA())
+	baseCommit, err := client.GetCommit(ctx, project, base.GetSHA())
 	if err != nil {
-		return errors.Wrap(err, "failed to get commit")
-	}
-	baseCommit := &common.Commit{
-		Sha:       ghc.GetSHA(),
-		Timestamp: ghc.GetCommitter().GetDate().Time,
+		return err
 	}
-	runs, _, err := client.Actions.ListWorkflowRunsByID(
-		ctx,
-		project.Owner,
-		project.Name,
-		workflowID,
-		&github.ListWorkflowRunsOptions{
-			Status:              "completed",
-			HeadSHA:             baseCommit.Sha,
-			ExcludePullRequests: true,
-		},
-	)
+	runs, err := client.ListCompletedWorkflowRunsForSHA(ctx, project, baseCommit.Sha)
 	if err != nil {
-		return errors.Wrap(err, "failed to list workflow runs by file name")
+		return err
 	}
-	if len(runs.WorkflowRuns) == 0 {
+	runs = filterByWorkflowID(runs, workflowID)
+	if len(runs) == 0 {
 		logger.Debug().
 			Str("commit_sha", baseCommit.Sha).
 			Msg("No base workflow runs found")
@@ -75,15 +64,17 @@ func processPR(
 	}
 
 	// Fetch report files for the PR base commit
-	baseRun := runs.WorkflowRuns[0]
+	baseRun := runs[0]
 	baseFiles, err := objdiff.FetchReportFiles(
 		ctx,
 		db,
 		logger,
+		config,
+		pub,
 		client,
 		project,
 		baseCommit,
-		baseRun.GetID(),
+		baseRun.ID,
 	)
 	if err != nil {
 		return err
@@ -91,7 +82,7 @@ func processPR(
 	if len(baseFiles) == 0 {
 		logger.Info().
 			Str("commit_sha", base.GetSHA()).
-			Int64("workflow_run_id", baseRun.GetID()).
+			Int64("workflow_run_id", baseRun.ID).
 			Msg("No base report files found")
 		return nil
 	}
@@ -99,6 +90,7 @@ func processPR(
 	// Generate changes for each report file
 	type versionChange struct {
 		Version string
+		Changes *common.Changes
 		Body    string
 	}
 	versionChanges := make([]versionChange, 0)
@@ -116,10 +108,14 @@ func processPR(
 					logger.Error().Err(err).Msg("Failed to generate changes")
 					return err
 				}
-				body := createChanges(changes)
+				if err = pub.Publish(ctx, changesEvent(project, &file, changes)); err != nil {
+					logger.Warn().Err(err).Msg("Failed to publish changes event")
+				}
+				body := checks.Summary(changes)
 				if body != "" {
 					versionChanges = append(versionChanges, versionChange{
 						Version: file.Version,
+						Changes: changes,
 						Body:    body,
 					})
 				}
@@ -131,271 +127,64 @@ func processPR(
 		return nil
 	}
 
-	// Generate PR comment body
-	body := "## Changes\n\n"
-	for _, vc := range versionChanges {
-		body += fmt.Sprintf(
-			"<details><summary>Version %s</summary>\n\n%s\n\n</details>\n\n",
-			vc.Version,
-			vc.Body,
-		)
-	}
-
-	// Update or create PR comment
-	err = upsertComment(ctx, client, project, prNum, body)
-	if err != nil {
-		return err
+	reportMode := config.ReportMode
+	if reportMode == "" {
+		reportMode = reportModeComment
 	}
-	return nil
-}
 
-func upsertComment(
-	ctx context.Context,
-	client *github.Client,
-	project *common.Project,
-	prNum int,
-	body string,
-) error {
-	sort := "created"
-	direction := "asc"
-	existing, _, err := client.Issues.ListComments(ctx, project.Owner, project.Name, prNum, &github.IssueListCommentsOptions{
-		Sort:      &sort,
-		Direction: &direction,
-	})
-	if err != nil {
-		return errors.Wrap(err, "failed to list existing comments")
-	}
-	commentID := int64(0)
-	for _, comment := range existing {
-		// TODO: update go-github to expose performed_via_github_app
-		if comment.GetUser().GetLogin() == "decompal[bot]" {
-			commentID = comment.GetID()
-			if comment.GetBody() == body {
-				// No changes
-				return nil
-			}
-			break
-		}
-	}
-	if commentID != 0 {
-		_, _, err = client.Issues.EditComment(ctx, project.Owner, project.Name, commentID, &github.IssueComment{
-			Body: github.String(body),
-		})
-		if err != nil {
-			return errors.Wrap(err, "failed to edit comment")
-		}
-	} else {
-		_, _, err = client.Issues.CreateComment(ctx, project.Owner, project.Name, prNum, &github.IssueComment{
-			Body: github.String(body),
-		})
-		if err != nil {
-			return errors.Wrap(err, "failed to create comment")
+	if reportMode == reportModeComment || reportMode == reportModeBoth {
+		// Generate PR comment body
+		body := "## Changes\n\n"
+		for _, vc := range versionChanges {
+			body += fmt.Sprintf(
+				"<details><summary>Version %s</summary>\n\n%s\n\n</details>\n\n",
+				vc.Version,
+				vc.Body,
+			)
 		}
-	}
-	return nil
-}
 
-func createChanges(changes *common.Changes) string {
-	out := "### Overall\n\n"
-	overallTable := measuresTable(changes.From, changes.To)
-	if overallTable == "" {
-		if len(changes.Units) == 0 {
-			return ""
+		// Update or create PR comment
+		if err = upsertComment(ctx, client, project, prNum, body); err != nil {
+			return err
 		}
-		out += "No changes\n\n"
-	} else {
-		out += overallTable + "\n\n"
 	}
-	for _, unit := range changes.Units {
-		out += fmt.Sprintf("---\n### `%s`\n\n", unit.Name)
-		unitTable := measuresTable(unit.From, unit.To)
-		if unitTable != "" {
-			out += unitTable + "\n\n"
-		}
-		functionsTable := changeItemTable("Functions", unit.Functions)
-		if functionsTable != "" {
-			out += functionsTable + "\n\n"
-		}
-	}
-	return out
-}
 
-func changeItemTable(name string, items []*common.ChangeItem) string {
-	header := fmt.Sprintf("|%s|Previous|Current|Change|\n|-|-|-|-|", name)
-	rows := make([]string, 0)
-	for _, item := range items {
-		row := changeItemInfoRow(item)
-		if row != "" {
-			rows = append(rows, row)
+	if reportMode == reportModeCheck || reportMode == reportModeBoth {
+		for _, vc := range versionChanges {
+			if err = upsertCheckRun(ctx, client, config, project, headCommit.Sha, vc.Version, vc.Changes); err != nil {
+				return err
+			}
 		}
 	}
-	if len(rows) == 0 {
-		return ""
-	}
-	return header + "\n" + strings.Join(rows, "\n")
-}
 
-const (
-	incArrow = "${\\color{green}▲}$"
-	decArrow = "${\\color{red}▼}$"
-)
-
-func floatArrow(diff float32) string {
-	if diff > 0 {
-		return " " + incArrow
-	}
-	if diff < 0 {
-		return " " + decArrow
-	}
-	return ""
+	return nil
 }
 
-func intArrow(diff int64) string {
-	if diff > 0 {
-		return " " + incArrow
-	}
-	if diff < 0 {
-		return " " + decArrow
+// filterByWorkflowID narrows runs down to those produced by the given workflow, keeping
+// only runs whose WorkflowID equals workflowID.
+func filterByWorkflowID(runs []forge.WorkflowRun, workflowID int64) []forge.WorkflowRun {
+	filtered := make([]forge.WorkflowRun, 0, len(runs))
+	for _, run := range runs {
+		if run.WorkflowID == workflowID {
+			filtered = append(filtered, run)
+		}
 	}
-	return ""
+	return filtered
 }
 
-func changeItemInfoRow(item *common.ChangeItem) string {
-	var fromPercent, toPercent float32
-	if item.From != nil {
-		fromPercent = item.From.FuzzyMatchPercent
-	}
-	if item.To != nil {
-		toPercent = item.To.FuzzyMatchPercent
-	}
-	if fromPercent == toPercent {
-		return ""
-	}
-	diff := toPercent - fromPercent
-	return fmt.Sprintf(
-		"|`%s`|%.2f%%|%.2f%%|%.2f%%%s|",
-		item.Name,
-		fromPercent,
-		toPercent,
-		diff,
-		floatArrow(diff),
-	)
+func upsertComment(ctx context.Context, client forge.Client, project *common.Project, prNum int, body string) error {
+	return client.UpsertIssueComment(ctx, project, prNum, forge.BotAuthor, body)
 }
 
-func measuresTable(prev, curr *common.Measures) string {
-	if prev == nil && curr == nil {
-		return ""
-	} else if prev == nil {
-		// TODO: added
-		prev = &common.Measures{}
-	} else if curr == nil {
-		// TODO: removed
-		curr = &common.Measures{}
-	}
-	header := "|Metric|Previous|Current|Change|\n|-|-|-|-|"
-	rows := make([]string, 0)
-	if prev.FuzzyMatchPercent != curr.FuzzyMatchPercent {
-		rows = append(rows, floatRow("Fuzzy match", prev.FuzzyMatchPercent, curr.FuzzyMatchPercent))
-	}
-	if prev.TotalCode != curr.TotalCode {
-		rows = append(rows, sizeRow("Total code", prev.TotalCode, curr.TotalCode))
-	}
-	if prev.MatchedCode != curr.MatchedCode ||
-		prev.MatchedCodePercent != curr.MatchedCodePercent {
-		rows = append(rows, intPercentRow(
-			"Matched code",
-			prev.MatchedCode,
-			prev.MatchedCodePercent,
-			curr.MatchedCode,
-			curr.MatchedCodePercent,
-		))
-	}
-	if prev.TotalData != curr.TotalData {
-		rows = append(rows, sizeRow("Total data", prev.TotalData, curr.TotalData))
-	}
-	if prev.MatchedData != curr.MatchedData ||
-		prev.MatchedDataPercent != curr.MatchedDataPercent {
-		rows = append(rows, intPercentRow(
-			"Matched data",
-			prev.MatchedData,
-			prev.MatchedDataPercent,
-			curr.MatchedData,
-			curr.MatchedDataPercent,
-		))
+// changesEvent builds the broker envelope for a computed diff, reporting the "to" side's
+// aggregate measures since that's the state the PR's head commit is actually in.
+func changesEvent(project *common.Project, file *common.ReportFile, changes *common.Changes) broker.Event {
+	return broker.Event{
+		Project:   fmt.Sprintf("%s/%s", project.Owner, project.Name),
+		Version:   file.Version,
+		Commit:    file.Commit.Sha,
+		Timestamp: file.Commit.Timestamp,
+		Measures:  changes.To,
+		ReportURL: fmt.Sprintf("/projects/%s/%s/%s/badge.svg", project.Owner, project.Name, file.Version),
 	}
-	if prev.TotalFunctions != curr.TotalFunctions {
-		rows = append(rows, intRow("Total functions", prev.TotalFunctions, curr.TotalFunctions))
-	}
-	if prev.MatchedFunctions != curr.MatchedFunctions ||
-		prev.MatchedFunctionsPercent != curr.MatchedFunctionsPercent {
-		rows = append(rows, intPercentRow(
-			"Matched functions",
-			uint64(prev.MatchedFunctions),
-			prev.MatchedFunctionsPercent,
-			uint64(curr.MatchedFunctions),
-			curr.MatchedFunctionsPercent,
-		))
-	}
-	if len(rows) == 0 {
-		return ""
-	}
-	return header + "\n" + strings.Join(rows, "\n")
-}
-
-func floatRow(name string, prev, curr float32) string {
-	diff := curr - prev
-	return fmt.Sprintf(
-		"|%s|%.2f%%|%.2f%%|%.2f%%%s|",
-		name,
-		prev,
-		curr,
-		diff,
-		floatArrow(diff),
-	)
-}
-
-func intRow(name string, prev, curr uint32) string {
-	diff := int64(curr) - int64(prev)
-	return fmt.Sprintf(
-		"|%s|%d|%d|%d%s|",
-		name,
-		prev,
-		curr,
-		diff,
-		intArrow(diff),
-	)
-}
-
-func sizeRow(name string, prev, curr uint64) string {
-	// TODO: format size
-	diff := int64(curr) - int64(prev)
-	return fmt.Sprintf(
-		"|%s|%d|%d|%d%s|",
-		name,
-		prev,
-		curr,
-		diff,
-		intArrow(diff),
-	)
-}
-
-func intPercentRow(
-	name string,
-	prevInt uint64,
-	prevPercent float32,
-	currInt uint64,
-	currPercent float32,
-) string {
-	diff := int64(currInt) - int64(prevInt)
-	return fmt.Sprintf(
-		"|%s|%d (%.2f%%)|%d (%.2f%%)|%d (%.2f%%)%s|",
-		name,
-		prevInt,
-		prevPercent,
-		currInt,
-		currPercent,
-		diff,
-		currPercent-prevPercent,
-		intArrow(diff),
-	)
 }
@@ -2,21 +2,96 @@ package handlers
 
 import (
 	"context"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/database"
+	"github.com/encounter/decompal/forge"
 	"github.com/palantir/go-githubapp/githubapp"
 	"github.com/rs/zerolog"
 	"time"
 )
 
-func backgroundTask(taskCtx context.Context, eventType, deliveryID string, run func(context.Context) error) {
-	logger := zerolog.Ctx(taskCtx).With().
-		Str(githubapp.LogKeyDeliveryID, deliveryID).
-		Str(githubapp.LogKeyEventType, eventType).
-		Logger()
-	ctx, cancel := context.WithDeadline(taskCtx, time.Now().Add(time.Minute))
-	defer cancel()
-	ctx = logger.WithContext(ctx)
-	err := run(ctx)
+const defaultWorkflowPollTimeout = 10 * time.Minute
+
+// TaskRunner persists webhook deliveries to pending_deliveries so that a delivery accepted
+// before a crash or restart is replayed rather than silently dropped. Actual event
+// processing runs on the jobqueue.Queue handed to each handler; jobqueue.Queue.Wait is what
+// drains in-flight work during shutdown.
+type TaskRunner struct {
+	db *database.DB
+}
+
+// NewTaskRunner creates a TaskRunner backed by db.
+func NewTaskRunner(db *database.DB) *TaskRunner {
+	return &TaskRunner{db: db}
+}
+
+// Enqueue records a delivery as pending, so it's never lost if the process is killed
+// between accepting the webhook and enqueueing it on the job queue.
+func (t *TaskRunner) Enqueue(ctx context.Context, deliveryID, eventType string, payload []byte) error {
+	return t.db.EnqueueDelivery(ctx, deliveryID, eventType, payload)
+}
+
+// Replay re-runs deliveries left in the "pending" state by a previous process, dispatching
+// each to the githubapp.EventHandler registered for its event type.
+func (t *TaskRunner) Replay(ctx context.Context, handlers map[string]githubapp.EventHandler) error {
+	logger := zerolog.Ctx(ctx)
+	deliveries, err := t.db.ListPendingDeliveries(ctx)
 	if err != nil {
-		logger.Error().Err(err).Msg("Background task failed")
+		return err
+	}
+	for _, delivery := range deliveries {
+		handler, ok := handlers[delivery.EventType]
+		if !ok {
+			logger.Warn().Str(githubapp.LogKeyEventType, delivery.EventType).
+				Msg("No handler registered for pending delivery's event type")
+			continue
+		}
+		logger.Info().
+			Str(githubapp.LogKeyDeliveryID, delivery.DeliveryID).
+			Str(githubapp.LogKeyEventType, delivery.EventType).
+			Msg("Replaying pending delivery")
+		if err := handler.Handle(ctx, delivery.EventType, delivery.DeliveryID, delivery.Payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForCompletedWorkflowRuns polls for completed workflow runs at the given commit SHA,
+// backing off exponentially since the webhook for a new head commit often arrives before
+// that commit's CI has finished.
+func waitForCompletedWorkflowRuns(
+	ctx context.Context,
+	client forge.Client,
+	timeout string,
+	project *common.Project,
+	sha string,
+) ([]forge.WorkflowRun, error) {
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		d = defaultWorkflowPollTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	delay := 5 * time.Second
+	const maxDelay = time.Minute
+	for {
+		runs, err := client.ListCompletedWorkflowRunsForSHA(ctx, project, sha)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			return runs, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
 	}
 }
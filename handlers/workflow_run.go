@@ -3,20 +3,48 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"github.com/encounter/decompal/broker"
 	"github.com/encounter/decompal/common"
 	"github.com/encounter/decompal/config"
 	"github.com/encounter/decompal/database"
+	"github.com/encounter/decompal/forge"
+	"github.com/encounter/decompal/jobqueue"
 	"github.com/encounter/decompal/objdiff"
 	"github.com/google/go-github/v63/github"
 	"github.com/palantir/go-githubapp/githubapp"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"strings"
+	"time"
 )
 
+// jobKindProcessWorkflowRun identifies the jobqueue job that fetches report files and
+// updates pull requests for a completed workflow_run or check_suite event.
+const jobKindProcessWorkflowRun = "process_workflow_run"
+
+// defaultWorkflowRunJobTimeout bounds a single process_workflow_run job. Replacing the old
+// fixed 1-minute goroutine deadline, this is generous enough for large artifact downloads,
+// and a job that still fails is retried with backoff instead of being dropped.
+const defaultWorkflowRunJobTimeout = 10 * time.Minute
+
+// workflowRunJobPayload is the jobqueue payload for jobKindProcessWorkflowRun. It wraps the
+// original webhook payload rather than any parsed event, so replaying a pending delivery and
+// processing its job both parse the event the same way.
+type workflowRunJobPayload struct {
+	EventType      string `json:"event_type"`
+	DeliveryID     string `json:"delivery_id"`
+	InstallationID int64  `json:"installation_id"`
+	Payload        []byte `json:"payload"`
+}
+
 type workflowRunHandler struct {
 	githubapp.ClientCreator
 	config  *config.AppConfig
 	db      *database.DB
 	taskCtx context.Context
+	tasks   *TaskRunner
+	broker  broker.Publisher
+	jobs    *jobqueue.Queue
 }
 
 func NewWorkflowRunHandler(
@@ -24,27 +52,48 @@ func NewWorkflowRunHandler(
 	config *config.AppConfig,
 	db *database.DB,
 	taskCtx context.Context,
+	tasks *TaskRunner,
+	pub broker.Publisher,
+	jobs *jobqueue.Queue,
 ) githubapp.EventHandler {
-	return &workflowRunHandler{
+	h := &workflowRunHandler{
 		ClientCreator: cc,
 		config:        config,
 		db:            db,
 		taskCtx:       taskCtx,
+		tasks:         tasks,
+		broker:        pub,
+		jobs:          jobs,
 	}
+	jobs.Register(jobKindProcessWorkflowRun, defaultWorkflowRunJobTimeout, h.runJob)
+	return h
 }
 
 func (h *workflowRunHandler) Handles() []string {
-	return []string{"workflow_run"}
+	return []string{"workflow_run", "check_suite", "check_run"}
 }
 
 func (h *workflowRunHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	switch eventType {
+	case "workflow_run":
+		return h.handleWorkflowRun(ctx, eventType, deliveryID, payload)
+	case "check_suite":
+		return h.handleCheckSuite(ctx, eventType, deliveryID, payload)
+	case "check_run":
+		return h.handleCheckRun(ctx, eventType, deliveryID, payload)
+	default:
+		return nil
+	}
+}
+
+func (h *workflowRunHandler) handleWorkflowRun(ctx context.Context, eventType, deliveryID string, payload []byte) error {
 	event := &github.WorkflowRunEvent{}
 	if err := json.Unmarshal(payload, event); err != nil {
 		return errors.Wrap(err, "failed to parse workflow run event payload")
 	}
 
 	installationID := githubapp.GetInstallationIDFromEvent(event)
-	ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, event.GetRepo())
+	_, logger := githubapp.PrepareRepoContext(ctx, installationID, event.GetRepo())
 	status := event.GetWorkflowRun().GetStatus()
 	if status != "completed" {
 		logger.Debug().
@@ -53,69 +102,322 @@ func (h *workflowRunHandler) Handle(ctx context.Context, eventType, deliveryID s
 		return nil
 	}
 
-	client, err := h.NewInstallationClient(installationID)
+	if err := h.tasks.Enqueue(h.taskCtx, deliveryID, eventType, payload); err != nil {
+		return err
+	}
+	return h.enqueueJob(h.taskCtx, eventType, deliveryID, installationID, payload)
+}
+
+// handleCheckSuite reacts to GitHub Actions' own check_suite, which fires once every check
+// run in the suite (including the Actions workflow itself) finishes. Only the Actions app's
+// check suite is handled, since a GitHub App that publishes check runs (as this one does in
+// "check"/"both" report mode) gets its own check_suite "completed" event for those check
+// runs, which would otherwise trigger an infinite loop of checks reacting to themselves.
+func (h *workflowRunHandler) handleCheckSuite(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	event := &github.CheckSuiteEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return errors.Wrap(err, "failed to parse check suite event payload")
+	}
+
+	suite := event.GetCheckSuite()
+	if event.GetAction() != "completed" || suite.GetApp().GetSlug() != "github-actions" {
+		return nil
+	}
+
+	installationID := githubapp.GetInstallationIDFromEvent(event)
+	if err := h.tasks.Enqueue(h.taskCtx, deliveryID, eventType, payload); err != nil {
+		return err
+	}
+	return h.enqueueJob(h.taskCtx, eventType, deliveryID, installationID, payload)
+}
+
+// handleCheckRun reacts to check_run "rerequested", which fires when a user clicks
+// "Re-run" on one of our own published checks from the Checks tab. Only our own check
+// runs are handled here; GitHub Actions' check runs are handled via check_suite instead,
+// for the same anti-loop reason handleCheckSuite only looks at the "github-actions" app.
+func (h *workflowRunHandler) handleCheckRun(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	event := &github.CheckRunEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return errors.Wrap(err, "failed to parse check run event payload")
+	}
+
+	checkRun := event.GetCheckRun()
+	if event.GetAction() != "rerequested" || checkRun.GetApp().GetSlug() == "github-actions" {
+		return nil
+	}
+	// Our own check runs are named "<checkRunName> / <version>" by upsertCheckRun.
+	if !strings.HasPrefix(checkRun.GetName(), checkRunName+" / ") {
+		return nil
+	}
+
+	installationID := githubapp.GetInstallationIDFromEvent(event)
+	if err := h.tasks.Enqueue(h.taskCtx, deliveryID, eventType, payload); err != nil {
+		return err
+	}
+	return h.enqueueJob(h.taskCtx, eventType, deliveryID, installationID, payload)
+}
+
+// enqueueJob persists a process_workflow_run job wrapping the raw webhook payload, so
+// ingestion survives a restart and a failed attempt is retried with backoff instead of
+// being killed at a fixed deadline. It's deduplicated on deliveryID so that Replay
+// re-processing a delivery RequeueStuckJobs already reset to pending doesn't enqueue a
+// second job for it.
+func (h *workflowRunHandler) enqueueJob(ctx context.Context, eventType, deliveryID string, installationID int64, payload []byte) error {
+	data, err := json.Marshal(workflowRunJobPayload{
+		EventType:      eventType,
+		DeliveryID:     deliveryID,
+		InstallationID: installationID,
+		Payload:        payload,
+	})
 	if err != nil {
 		return err
 	}
+	return h.jobs.EnqueueForDelivery(ctx, jobKindProcessWorkflowRun, data, deliveryID)
+}
 
-	go backgroundTask(h.taskCtx, eventType, deliveryID, func(ctx context.Context) error {
-		repo := event.GetRepo()
-		// Re-prepare logger instead of using the one from the request context
-		ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+// runJob is the jobqueue handler for jobKindProcessWorkflowRun. It re-parses the wrapped
+// webhook payload and dispatches to the same logic handleWorkflowRun/handleCheckSuite used
+// to run inline, then records the delivery's outcome for Replay.
+func (h *workflowRunHandler) runJob(ctx context.Context, data []byte) error {
+	var p workflowRunJobPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
 
-		// Fetch report files for the current workflow run
-		project := &common.Project{
-			ID:    repo.GetID(),
-			Owner: repo.GetOwner().GetLogin(),
-			Name:  repo.GetName(),
-		}
-		run := event.GetWorkflowRun()
-		runId := run.GetID()
-		commit := &common.Commit{
-			Sha:       run.GetHeadCommit().GetSHA(),
-			Timestamp: run.GetHeadCommit().GetCommitter().GetDate().Time,
+	var err error
+	switch p.EventType {
+	case "workflow_run":
+		err = h.processWorkflowRunEvent(ctx, p.InstallationID, p.Payload)
+	case "check_suite":
+		err = h.processCheckSuiteEvent(ctx, p.InstallationID, p.Payload)
+	case "check_run":
+		err = h.processCheckRunEvent(ctx, p.InstallationID, p.Payload)
+	default:
+		err = errors.Errorf("unknown event type %q for %s job", p.EventType, jobKindProcessWorkflowRun)
+	}
+
+	logger := zerolog.Ctx(ctx)
+	if err != nil {
+		if markErr := h.db.MarkDeliveryFailed(ctx, p.DeliveryID); markErr != nil {
+			logger.Error().Err(markErr).Msg("Failed to record delivery failure")
 		}
-		files, err := objdiff.FetchReportFiles(
+		return err
+	}
+	if markErr := h.db.MarkDeliveryDone(ctx, p.DeliveryID); markErr != nil {
+		logger.Error().Err(markErr).Msg("Failed to record delivery completion")
+	}
+	return nil
+}
+
+func (h *workflowRunHandler) processWorkflowRunEvent(ctx context.Context, installationID int64, payload []byte) error {
+	event := &github.WorkflowRunEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return errors.Wrap(err, "failed to parse workflow run event payload")
+	}
+
+	repo := event.GetRepo()
+	// Re-prepare logger instead of using the one from the request context
+	ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+
+	ghClient, err := h.NewInstallationClient(installationID)
+	if err != nil {
+		return err
+	}
+	client := forge.NewGitHubClient(ghClient)
+
+	// Fetch report files for the current workflow run
+	project := &common.Project{
+		ID:    repo.GetID(),
+		Owner: repo.GetOwner().GetLogin(),
+		Name:  repo.GetName(),
+	}
+	run := event.GetWorkflowRun()
+	runId := run.GetID()
+	commit := &common.Commit{
+		Sha:       run.GetHeadCommit().GetSHA(),
+		Timestamp: run.GetHeadCommit().GetCommitter().GetDate().Time,
+	}
+	files, err := objdiff.FetchReportFiles(
+		ctx,
+		h.db,
+		logger,
+		h.config,
+		h.broker,
+		client,
+		project,
+		commit,
+		runId,
+	)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		logger.Info().Msg("No report files found")
+		return nil
+	}
+
+	// Process all pull requests associated with the workflow run
+	for _, pr := range run.PullRequests {
+		if err = processPR(
 			ctx,
 			h.db,
-			logger,
-			client,
-			project,
+			h.config,
+			h.broker,
+			installationID,
+			pr,
 			commit,
-			runId,
-		)
+			client,
+			repo,
+			run.GetWorkflowID(),
+			files,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *workflowRunHandler) processCheckSuiteEvent(ctx context.Context, installationID int64, payload []byte) error {
+	event := &github.CheckSuiteEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return errors.Wrap(err, "failed to parse check suite event payload")
+	}
+
+	suite := event.GetCheckSuite()
+	repo := event.GetRepo()
+	// Re-prepare logger instead of using the one from the request context
+	ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+
+	ghClient, err := h.NewInstallationClient(installationID)
+	if err != nil {
+		return err
+	}
+	client := forge.NewGitHubClient(ghClient)
+
+	project := &common.Project{
+		ID:    repo.GetID(),
+		Owner: repo.GetOwner().GetLogin(),
+		Name:  repo.GetName(),
+	}
+	commit, err := client.GetCommit(ctx, project, suite.GetHeadSHA())
+	if err != nil {
+		return err
+	}
+	runs, err := client.ListCompletedWorkflowRunsForSHA(ctx, project, commit.Sha)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		logger.Debug().Msg("No completed workflow runs found for check suite")
+		return nil
+	}
+
+	var files []common.ReportFile
+	var run forge.WorkflowRun
+	for _, run = range runs {
+		files, err = objdiff.FetchReportFiles(ctx, h.db, logger, h.config, h.broker, client, project, commit, run.ID)
 		if err != nil {
 			return err
 		}
-		if len(files) == 0 {
-			logger.Info().Msg("No report files found")
-			return nil
+		if len(files) > 0 {
+			break
 		}
+	}
+	if len(files) == 0 {
+		logger.Info().Msg("No report files found")
+		return nil
+	}
 
-		// Process all pull requests associated with the workflow run
-		prs := event.GetWorkflowRun().PullRequests
-		if prs != nil {
-			for _, pr := range prs {
-				err = processPR(
-					ctx,
-					h.db,
-					h.config,
-					installationID,
-					pr,
-					commit,
-					client,
-					repo,
-					run.GetWorkflowID(),
-					files,
-				)
-				if err != nil {
-					return err
-				}
-			}
+	for _, pr := range suite.PullRequests {
+		if err = processPR(
+			ctx,
+			h.db,
+			h.config,
+			h.broker,
+			installationID,
+			pr,
+			commit,
+			client,
+			repo,
+			run.WorkflowID,
+			files,
+		); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+func (h *workflowRunHandler) processCheckRunEvent(ctx context.Context, installationID int64, payload []byte) error {
+	event := &github.CheckRunEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return errors.Wrap(err, "failed to parse check run event payload")
+	}
+
+	checkRun := event.GetCheckRun()
+	repo := event.GetRepo()
+	// Re-prepare logger instead of using the one from the request context
+	ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+
+	ghClient, err := h.NewInstallationClient(installationID)
+	if err != nil {
+		return err
+	}
+	client := forge.NewGitHubClient(ghClient)
 
+	project := &common.Project{
+		ID:    repo.GetID(),
+		Owner: repo.GetOwner().GetLogin(),
+		Name:  repo.GetName(),
+	}
+	commit, err := client.GetCommit(ctx, project, checkRun.GetHeadSHA())
+	if err != nil {
+		return err
+	}
+	runs, err := client.ListCompletedWorkflowRunsForSHA(ctx, project, commit.Sha)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		logger.Debug().Msg("No completed workflow runs found for check run")
 		return nil
-	})
+	}
+
+	var files []common.ReportFile
+	var run forge.WorkflowRun
+	for _, run = range runs {
+		files, err = objdiff.FetchReportFiles(ctx, h.db, logger, h.config, h.broker, client, project, commit, run.ID)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			break
+		}
+	}
+	if len(files) == 0 {
+		logger.Info().Msg("No report files found")
+		return nil
+	}
+
+	for _, pr := range checkRun.PullRequests {
+		if err = processPR(
+			ctx,
+			h.db,
+			h.config,
+			h.broker,
+			installationID,
+			pr,
+			commit,
+			client,
+			repo,
+			run.WorkflowID,
+			files,
+		); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,144 @@
+// Package jobqueue implements a small persistent job queue backed by the database's jobs
+// table, so long-running background work survives a process restart instead of being
+// dropped when a fixed deadline expires.
+package jobqueue
+
+import (
+	"context"
+	"github.com/encounter/decompal/database"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"sync"
+	"time"
+)
+
+// Handler processes a single job's payload. ctx is bounded by the timeout registered for
+// the job's kind.
+type Handler func(ctx context.Context, payload []byte) error
+
+// defaultTimeout bounds a job's handler when its kind was registered with a zero timeout.
+const defaultTimeout = 10 * time.Minute
+
+// defaultConcurrency is used when New is given a non-positive worker count.
+const defaultConcurrency = 4
+
+// pollInterval is how often idle workers check for newly-due jobs.
+const pollInterval = 5 * time.Second
+
+// Queue runs a worker pool that claims and processes jobs persisted in the jobs table.
+type Queue struct {
+	db       *database.DB
+	handlers map[string]registeredHandler
+	workers  int
+	wg       sync.WaitGroup
+}
+
+type registeredHandler struct {
+	fn      Handler
+	timeout time.Duration
+}
+
+// New creates a Queue with the given worker concurrency, backed by db. A non-positive
+// workers count falls back to defaultConcurrency.
+func New(db *database.DB, workers int) *Queue {
+	if workers <= 0 {
+		workers = defaultConcurrency
+	}
+	return &Queue{db: db, handlers: make(map[string]registeredHandler), workers: workers}
+}
+
+// Register installs the handler for jobs of the given kind, bounding each run to timeout.
+// A zero timeout uses defaultTimeout. Register must be called before Start.
+func (q *Queue) Register(kind string, timeout time.Duration, fn Handler) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	q.handlers[kind] = registeredHandler{fn: fn, timeout: timeout}
+}
+
+// Enqueue persists a new job of the given kind, to be picked up by the worker pool.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload []byte) error {
+	_, err := q.db.EnqueueJob(ctx, kind, payload)
+	return err
+}
+
+// EnqueueForDelivery is Enqueue, but deduplicated on deliveryID: enqueueing a second job for
+// a delivery that already has one (e.g. a replayed webhook delivery after RequeueStuckJobs
+// reset its original job back to pending) is a no-op rather than double-processing it.
+func (q *Queue) EnqueueForDelivery(ctx context.Context, kind string, payload []byte, deliveryID string) error {
+	_, err := q.db.EnqueueJobForDelivery(ctx, kind, payload, deliveryID)
+	return err
+}
+
+// Start requeues any jobs left "running" by a previous process (crashed mid-run) and
+// launches the worker pool. It returns once the requeue completes; workers keep running in
+// the background until ctx is canceled. Call Wait during shutdown to drain in-flight jobs.
+func (q *Queue) Start(ctx context.Context) error {
+	if err := q.db.RequeueStuckJobs(ctx); err != nil {
+		return err
+	}
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker(ctx)
+	}
+	return nil
+}
+
+// Wait blocks until every worker has stopped, i.e. ctx passed to Start has been canceled
+// and any in-flight job has finished.
+func (q *Queue) Wait() {
+	q.wg.Wait()
+}
+
+func (q *Queue) worker(ctx context.Context) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		job, err := q.db.ClaimJob(ctx)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to claim job")
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		q.run(ctx, job)
+	}
+}
+
+func (q *Queue) run(ctx context.Context, job *database.Job) {
+	logger := zerolog.Ctx(ctx).With().
+		Str("job_kind", job.Kind).
+		Int64("job_id", job.ID).
+		Logger()
+
+	handler, ok := q.handlers[job.Kind]
+	if !ok {
+		logger.Error().Msg("No handler registered for job kind")
+		if err := q.db.MarkJobFailed(ctx, job.ID, errors.Errorf("no handler registered for kind %q", job.Kind)); err != nil {
+			logger.Error().Err(err).Msg("Failed to record unhandled job")
+		}
+		return
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, handler.timeout)
+	defer cancel()
+	runCtx = logger.WithContext(runCtx)
+
+	if err := handler.fn(runCtx, job.Payload); err != nil {
+		logger.Error().Err(err).Msg("Job failed")
+		if markErr := q.db.MarkJobFailed(ctx, job.ID, err); markErr != nil {
+			logger.Error().Err(markErr).Msg("Failed to record job failure")
+		}
+		return
+	}
+	if err := q.db.MarkJobDone(ctx, job.ID); err != nil {
+		logger.Error().Err(err).Msg("Failed to record job completion")
+	}
+}
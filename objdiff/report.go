@@ -2,15 +2,17 @@ package objdiff
 
 import (
 	"context"
+	"fmt"
+	"github.com/encounter/decompal/broker"
 	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/config"
 	"github.com/encounter/decompal/database"
+	"github.com/encounter/decompal/forge"
 	"github.com/encounter/decompal/zipstream"
-	"github.com/google/go-github/v63/github"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"google.golang.org/protobuf/proto"
 	"io"
-	"net/http"
 	"regexp"
 	"sort"
 	"strings"
@@ -19,11 +21,17 @@ import (
 
 var artifactNameRegex = regexp.MustCompile(`^(?P<version>[A-z0-9_\-]+)[_-]report(?:[_-].*)?$`)
 
+// defaultMaxArtifactBytes bounds how much of an artifact download FetchReportFiles will
+// read, used when AppConfig.MaxArtifactBytes is unset.
+const defaultMaxArtifactBytes = 128 << 20 // 128 MiB
+
 func FetchReportFiles(
 	ctx context.Context,
 	db *database.DB,
 	logger zerolog.Logger,
-	client *github.Client,
+	cfg *config.AppConfig,
+	pub broker.Publisher,
+	source ArtifactSource,
 	project *common.Project,
 	commit *common.Commit,
 	runId int64,
@@ -33,7 +41,7 @@ func FetchReportFiles(
 		Int64("workflow_run_id", runId).
 		Logger()
 
-	artifacts, _, err := client.Actions.ListWorkflowRunArtifacts(ctx, project.Owner, project.Name, runId, nil)
+	artifacts, err := source.ListWorkflowRunArtifacts(ctx, project, forge.WorkflowRun{ID: runId})
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -42,13 +50,13 @@ func FetchReportFiles(
 	}
 
 	files := make([]common.ReportFile, 0)
-	for _, artifact := range artifacts.Artifacts {
+	for _, artifact := range artifacts {
 		logger := logger.With().
-			Str("artifact_name", artifact.GetName()).
-			Int64("artifact_id", artifact.GetID()).
+			Str("artifact_name", artifact.Name).
+			Int64("artifact_id", artifact.ID).
 			Logger()
 
-		matches := artifactNameRegex.FindStringSubmatch(artifact.GetName())
+		matches := artifactNameRegex.FindStringSubmatch(artifact.Name)
 		if matches == nil {
 			//logger.Debug().Msg("Skipping artifact")
 			continue
@@ -58,7 +66,7 @@ func FetchReportFiles(
 		start := time.Now()
 		existing, err := db.GetReport(ctx, project.ID, version, commit.Sha)
 		if err != nil {
-			logger.Fatal().Err(err).Msg("failed to check if report exists")
+			return nil, errors.Wrap(err, "failed to check if report exists")
 		}
 		if existing != nil {
 			end := time.Now()
@@ -69,24 +77,19 @@ func FetchReportFiles(
 			continue
 		}
 
-		artifactUrl, _, err := client.Actions.DownloadArtifact(ctx, project.Owner, project.Name, artifact.GetID(), 3)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to get artifact download url")
-		}
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, artifactUrl.String(), nil)
+		body, err := source.DownloadArtifact(ctx, project, artifact)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create download request")
+			return nil, errors.Wrap(err, "failed to download artifact")
 		}
 
-		req.Header.Set("User-Agent", client.UserAgent)
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to download artifact")
+		maxArtifactBytes := int64(cfg.MaxArtifactBytes)
+		if maxArtifactBytes <= 0 {
+			maxArtifactBytes = defaultMaxArtifactBytes
 		}
+		limited := &limitedReadCloser{r: io.LimitReader(body, maxArtifactBytes), c: body}
 
-		report, err := findReportFile(logger, resp.Body)
-		_ = resp.Body.Close()
+		report, err := findReportFileParallel(logger, limited, cfg)
+		_ = limited.Close()
 		if err != nil {
 			return nil, err
 		}
@@ -105,6 +108,11 @@ func FetchReportFiles(
 			logger.Info().
 				Str("duration", end.Sub(start).String()).
 				Msg("Inserted report")
+
+			if err = pub.Publish(ctx, reportEvent(&file)); err != nil {
+				logger.Warn().Err(err).Msg("Failed to publish report event")
+			}
+
 			files = append(files, file)
 		}
 	}
@@ -116,11 +124,50 @@ func FetchReportFiles(
 	return files, nil
 }
 
-// findReportFile reads the zip stream and writes the report file to the output path
-// Returns true if the report file was found and written
-func findReportFile(logger zerolog.Logger, r io.Reader) (*common.Report, error) {
+// reportEvent builds the broker envelope for a newly-ingested report. ReportURL points at
+// the badge route rather than a dedicated report endpoint, since that's the only route
+// that currently serves a single version's latest data.
+func reportEvent(file *common.ReportFile) broker.Event {
+	return broker.Event{
+		Project:   fmt.Sprintf("%s/%s", file.Project.Owner, file.Project.Name),
+		Version:   file.Version,
+		Commit:    file.Commit.Sha,
+		Timestamp: file.Commit.Timestamp,
+		Measures:  file.Report.Measures,
+		ReportURL: fmt.Sprintf("/projects/%s/%s/%s/badge.svg", file.Project.Owner, file.Project.Name, file.Version),
+	}
+}
+
+// defaultMaxReportBytes and defaultMaxReportEntries bound FindReportFile's work when
+// AppConfig.MaxReportBytes/MaxReportEntries are unset.
+const (
+	defaultMaxReportBytes   = 32 << 20 // 32 MiB
+	defaultMaxReportEntries = 10000
+)
+
+// FindReportFile scans a zip stream for a report.json/report.binpb/report.pb entry and
+// parses it, returning nil if none is present. It checks each entry's name before reading
+// its data, stops scanning once AppConfig.MaxReportEntries entries have been seen, and
+// caps how many bytes it will read out of a matching entry at AppConfig.MaxReportBytes, so
+// a huge or hostile artifact can't be used to exhaust memory. It's exported so artifact
+// sources that receive a report archive directly (e.g. an HTTP upload) can reuse the same
+// parsing logic.
+func FindReportFile(logger zerolog.Logger, r io.Reader, cfg *config.AppConfig) (*common.Report, error) {
+	maxBytes := int64(cfg.MaxReportBytes)
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxReportBytes
+	}
+	maxEntries := cfg.MaxReportEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxReportEntries
+	}
+
 	zr := zipstream.NewReader(r)
-	for {
+	for i := 0; ; i++ {
+		if i >= maxEntries {
+			return nil, errors.Errorf("zip file has at least %d entries", maxEntries)
+		}
+
 		entry, err := zr.Next()
 		if err != nil {
 			if err == io.EOF {
@@ -129,32 +176,111 @@ func findReportFile(logger zerolog.Logger, r io.Reader) (*common.Report, error)
 			return nil, errors.Wrap(err, "failed to get next entry")
 		}
 
-		data, err := io.ReadAll(entry)
+		isJson := strings.HasSuffix(entry.Name, "report.json")
+		isProto := strings.HasSuffix(entry.Name, "report.binpb") || strings.HasSuffix(entry.Name, "report.pb")
+		if !isJson && !isProto {
+			// Not a report file, but still bound how much of it we'll decompress: left
+			// unbounded, a huge decoy entry ahead of the real report file would let a
+			// hostile artifact act as a zip bomb before we ever reach the size check below.
+			skipped, err := io.CopyN(io.Discard, entry, maxBytes+1)
+			if err != nil && err != io.EOF {
+				return nil, errors.Wrap(err, "failed to skip entry")
+			}
+			if skipped > maxBytes {
+				return nil, errors.Errorf("zip entry %q exceeds max size of %d bytes", entry.Name, maxBytes)
+			}
+			continue
+		}
+
+		report, err := parseReportEntry(entry.Name, entry, isJson, maxBytes)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to read report file")
+			return nil, err
 		}
-		if strings.HasSuffix(entry.Name, "report.json") {
-			report := &common.Report{}
-			err := common.ParseReportJson(data, report)
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to read report file")
-			}
-			logger.Info().
-				Str("filename", entry.Name).
-				Msg("Read report file")
-			return report, nil
-		} else if strings.HasSuffix(entry.Name, "report.binpb") ||
-			strings.HasSuffix(entry.Name, "report.pb") {
-			report := &common.Report{}
-			err = proto.Unmarshal(data, report)
-			if err != nil {
-				return nil, err
+		logger.Info().
+			Str("filename", entry.Name).
+			Msg("Read report file")
+		return report, nil
+	}
+	return nil, nil
+}
+
+// findReportFileParallel is FindReportFile, but walks the zip stream with a
+// zipstream.ParallelReader instead of zipstream.Reader, so the network read of artifact data
+// still arriving from the forge overlaps with inflating and parsing entries already
+// downloaded. Used for the live artifact-download path; the HTTP upload path in
+// artifactsource already has the whole body in memory before scanning it, so it has nothing
+// to overlap and uses FindReportFile directly instead.
+func findReportFileParallel(logger zerolog.Logger, r io.Reader, cfg *config.AppConfig) (*common.Report, error) {
+	maxBytes := int64(cfg.MaxReportBytes)
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxReportBytes
+	}
+	maxEntries := cfg.MaxReportEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxReportEntries
+	}
+
+	zr := zipstream.NewParallelReader(r, zipstream.ParallelReaderOptions{MaxEntryBytes: maxBytes})
+	defer func() { _ = zr.Close() }()
+	for i := 0; ; i++ {
+		if i >= maxEntries {
+			return nil, errors.Errorf("zip file has at least %d entries", maxEntries)
+		}
+
+		entry, err := zr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
 			}
-			logger.Info().
-				Str("filename", entry.Name).
-				Msg("Read report file")
-			return report, nil
+			return nil, errors.Wrap(err, "failed to get next entry")
 		}
+
+		isJson := strings.HasSuffix(entry.Name, "report.json")
+		isProto := strings.HasSuffix(entry.Name, "report.binpb") || strings.HasSuffix(entry.Name, "report.pb")
+		if !isJson && !isProto {
+			continue
+		}
+
+		report, err := parseReportEntry(entry.Name, entry, isJson, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info().
+			Str("filename", entry.Name).
+			Msg("Read report file")
+		return report, nil
 	}
 	return nil, nil
 }
+
+// parseReportEntry reads up to maxBytes from r (a matched report.json/report.binpb/report.pb
+// entry named name) and parses it, erroring if the entry turns out to exceed maxBytes.
+func parseReportEntry(name string, r io.Reader, isJson bool, maxBytes int64) (*common.Report, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read report file")
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errors.Errorf("report file %q exceeds max size of %d bytes", name, maxBytes)
+	}
+
+	report := &common.Report{}
+	if isJson {
+		if err := common.ParseReportJson(data, report); err != nil {
+			return nil, errors.Wrap(err, "failed to read report file")
+		}
+	} else if err := proto.Unmarshal(data, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// limitedReadCloser wraps a Reader cap with an unrelated Closer, so
+// io.LimitReader(body, n) can still be Close()d through its original ReadCloser.
+type limitedReadCloser struct {
+	r io.Reader
+	c io.Closer
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) { return l.r.Read(p) }
+func (l *limitedReadCloser) Close() error               { return l.c.Close() }
@@ -0,0 +1,18 @@
+package objdiff
+
+import (
+	"context"
+	"github.com/encounter/decompal/common"
+	"github.com/encounter/decompal/forge"
+	"io"
+)
+
+// ArtifactSource lists and downloads the CI build artifacts that might contain an objdiff
+// report. It's a narrower interface than forge.Client so that FetchReportFiles isn't tied to
+// a full forge implementation (issue comments, check runs, etc.) - a CI system that only
+// produces artifacts, like Gitea Actions, can implement just this.
+// Every forge.Client implementation satisfies ArtifactSource already.
+type ArtifactSource interface {
+	ListWorkflowRunArtifacts(ctx context.Context, project *common.Project, run forge.WorkflowRun) ([]forge.Artifact, error)
+	DownloadArtifact(ctx context.Context, project *common.Project, artifact forge.Artifact) (io.ReadCloser, error)
+}
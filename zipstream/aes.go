@@ -0,0 +1,178 @@
+package zipstream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// aeMethod is the zip "compression method" value used for WinZip AES-encrypted entries;
+// the real compression method is carried in the AE-x extra field instead.
+const aeMethod = 99
+
+// aeExtraID identifies the AE-x extra field (WinZip AES spec) carrying the AES strength
+// and real compression method for an aeMethod entry.
+const aeExtraID = 0x9901
+
+// aesMACLen is the length, in bytes, of the HMAC-SHA1 authentication tag WinZip AES
+// appends after an entry's ciphertext.
+const aesMACLen = 10
+
+// aesPBKDF2Iterations is fixed by the WinZip AES spec.
+const aesPBKDF2Iterations = 1000
+
+var (
+	// ErrAESPassword indicates the password-verification bytes following an AES entry's
+	// salt didn't match, meaning the wrong password was supplied.
+	ErrAESPassword = errors.New("zipstream: wrong password for AES-encrypted entry")
+	// ErrAESAuthentication indicates an AES entry's trailing HMAC-SHA1 tag didn't match
+	// its ciphertext, meaning the data was corrupted or tampered with.
+	ErrAESAuthentication = errors.New("zipstream: AES authentication tag mismatch")
+)
+
+// aeExtra is the parsed contents of an AE-x extra field.
+type aeExtra struct {
+	version  int
+	strength int
+	method   uint16
+}
+
+// parseAEExtra parses an AE-x extra field body (the 7 bytes following its tag/size).
+func parseAEExtra(buf readBuf) (aeExtra, bool) {
+	if len(buf) < 7 {
+		return aeExtra{}, false
+	}
+	var e aeExtra
+	e.version = int(buf.uint16())
+	buf.uint16() // vendor ID, always "AE"; not otherwise checked
+	e.strength = int(buf.uint8())
+	e.method = buf.uint16()
+	return e, true
+}
+
+// aesSaltLen returns the salt length, in bytes, for an AE-x strength value (1 = AES-128,
+// 2 = AES-192, 3 = AES-256), or 0 if strength is invalid.
+func aesSaltLen(strength int) int {
+	switch strength {
+	case 1:
+		return 8
+	case 2:
+		return 12
+	case 3:
+		return 16
+	default:
+		return 0
+	}
+}
+
+// aesKeyLen returns the AES key length, in bytes, for an AE-x strength value, or 0 if
+// strength is invalid.
+func aesKeyLen(strength int) int {
+	switch strength {
+	case 1:
+		return 16
+	case 2:
+		return 24
+	case 3:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// pbkdf2HMACSHA1 derives length bytes of key material from password and salt using
+// PBKDF2-HMAC-SHA1 (RFC 8018).
+func pbkdf2HMACSHA1(password, salt []byte, iterations, length int) []byte {
+	prf := hmac.New(sha1.New, password)
+	hashLen := prf.Size()
+	numBlocks := (length + hashLen - 1) / hashLen
+
+	dk := make([]byte, 0, numBlocks*hashLen)
+	block := make([]byte, len(salt)+4)
+	copy(block, salt)
+	for i := 1; i <= numBlocks; i++ {
+		binary.BigEndian.PutUint32(block[len(salt):], uint32(i))
+		prf.Reset()
+		prf.Write(block)
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for j := 1; j < iterations; j++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(nil)
+			for k := range t {
+				t[k] ^= u[k]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:length]
+}
+
+// newAESDecryptor verifies the password-verification bytes derived alongside the AES key,
+// returning ErrAESPassword if they don't match pv. On success it returns a reader that
+// decrypts ciphertext with AES-CTR, and the HMAC-SHA1 that the entry's trailing
+// authentication tag must be checked against once ciphertext has been fully read.
+func newAESDecryptor(password string, ae aeExtra, salt, pv []byte, ciphertext io.Reader) (io.Reader, hash.Hash, error) {
+	keyLen := aesKeyLen(ae.strength)
+	if keyLen == 0 {
+		return nil, nil, fmt.Errorf("zipstream: unknown AES strength %d", ae.strength)
+	}
+
+	derived := pbkdf2HMACSHA1([]byte(password), salt, aesPBKDF2Iterations, keyLen*2+2)
+	encKey, authKey, wantPV := derived[:keyLen], derived[keyLen:keyLen*2], derived[keyLen*2:]
+	if !hmac.Equal(pv, wantPV) {
+		return nil, nil, ErrAESPassword
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("zipstream: unable to create AES cipher: %w", err)
+	}
+
+	mac := hmac.New(sha1.New, authKey)
+	return newAESCTRReader(block, io.TeeReader(ciphertext, mac)), mac, nil
+}
+
+// aesCTRReader decrypts a WinZip AES ciphertext stream with AES-CTR, using the
+// little-endian counter block (starting at 1) the WinZip AES spec requires instead of the
+// big-endian convention crypto/cipher.NewCTR assumes.
+type aesCTRReader struct {
+	block   cipher.Block
+	src     io.Reader
+	counter uint64
+	ks      [aes.BlockSize]byte
+	ksPos   int
+	buf     []byte
+}
+
+func newAESCTRReader(block cipher.Block, src io.Reader) *aesCTRReader {
+	return &aesCTRReader{block: block, src: src, counter: 1, ksPos: aes.BlockSize}
+}
+
+func (r *aesCTRReader) Read(p []byte) (int, error) {
+	if cap(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+	n, err := r.src.Read(buf)
+	for i := 0; i < n; i++ {
+		if r.ksPos == aes.BlockSize {
+			var counterBlock [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(counterBlock[:8], r.counter)
+			r.block.Encrypt(r.ks[:], counterBlock[:])
+			r.counter++
+			r.ksPos = 0
+		}
+		p[i] = buf[i] ^ r.ks[r.ksPos]
+		r.ksPos++
+	}
+	return n, err
+}
@@ -0,0 +1,116 @@
+package zipstream
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// buildAESEntry encrypts data as a WinZip AE-1 (strength 1 = AES-128, real method = Store)
+// entry using the package's own key derivation and CTR reader (symmetric encrypt/decrypt),
+// and returns the full local-file-header-plus-ciphertext bytes zipstream.Reader expects.
+// AE-1 carries a real CRC-32 in the local header/descriptor, unlike AE-2 which zeroes it.
+func buildAESEntry(t *testing.T, password string, data []byte) []byte {
+	t.Helper()
+	const strength = 1 // AES-128
+	salt := bytes.Repeat([]byte{0x42}, aesSaltLen(strength))
+
+	keyLen := aesKeyLen(strength)
+	derived := pbkdf2HMACSHA1([]byte(password), salt, aesPBKDF2Iterations, keyLen*2+2)
+	encKey, authKey, pv := derived[:keyLen], derived[keyLen:keyLen*2], derived[keyLen*2:]
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		t.Fatalf("aes.NewCipher: %v", err)
+	}
+	ctr := newAESCTRReader(block, bytes.NewReader(data))
+	ciphertext, err := io.ReadAll(ctr)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	mac := hmac.New(sha1.New, authKey)
+	mac.Write(ciphertext)
+	tag := mac.Sum(nil)[:aesMACLen]
+
+	compressedSize := len(salt) + len(pv) + len(ciphertext) + aesMACLen
+
+	extra := make([]byte, 7)
+	binary.LittleEndian.PutUint16(extra[0:2], 1) // AE-1
+	extra[2], extra[3] = 'A', 'E'                // vendor ID
+	extra[4] = strength
+	binary.LittleEndian.PutUint16(extra[5:7], uint16(0)) // real method: Store
+
+	extraField := make([]byte, 4+len(extra))
+	binary.LittleEndian.PutUint16(extraField[0:2], aeExtraID)
+	binary.LittleEndian.PutUint16(extraField[2:4], uint16(len(extra)))
+	copy(extraField[4:], extra)
+
+	var buf bytes.Buffer
+	writeLocalHeader(&buf, 1, aeMethod, crc32.ChecksumIEEE(data), uint32(compressedSize), uint32(len(data)), "report.json", extraField)
+	buf.Write(salt)
+	buf.Write(pv)
+	buf.Write(ciphertext)
+	buf.Write(tag)
+	writeTerminator(&buf)
+	return buf.Bytes()
+}
+
+func TestAES_RoundTripWithCorrectPassword(t *testing.T) {
+	data := []byte("secret report contents")
+	raw := buildAESEntry(t, "hunter2", data)
+
+	zr := NewReader(bytes.NewReader(raw))
+	zr.SetPassword("hunter2")
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	got, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("data = %q, want %q", got, data)
+	}
+	if _, err := zr.Next(); err != io.EOF {
+		t.Fatalf("second Next() = %v, want io.EOF (authentication tag should verify)", err)
+	}
+}
+
+func TestAES_WrongPassword(t *testing.T) {
+	data := []byte("secret report contents")
+	raw := buildAESEntry(t, "hunter2", data)
+
+	zr := NewReader(bytes.NewReader(raw))
+	zr.SetPassword("wrong-password")
+	if _, err := zr.Next(); !errors.Is(err, ErrAESPassword) {
+		t.Fatalf("Next() = %v, want ErrAESPassword", err)
+	}
+}
+
+func TestAES_AuthenticationTagMismatch(t *testing.T) {
+	data := []byte("secret report contents")
+	raw := buildAESEntry(t, "hunter2", data)
+	// Corrupt the last byte of the trailing HMAC authentication tag.
+	raw[len(raw)-1-4] ^= 0xFF
+
+	zr := NewReader(bytes.NewReader(raw))
+	zr.SetPassword("hunter2")
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if _, err := io.ReadAll(entry); err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if _, err := zr.Next(); !errors.Is(err, ErrAESAuthentication) {
+		t.Fatalf("second Next() = %v, want ErrAESAuthentication", err)
+	}
+}
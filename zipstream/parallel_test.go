@@ -0,0 +1,141 @@
+package zipstream
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// buildStoredZip writes a minimal stored-method zip stream containing one entry per name/data
+// pair in order, suitable for NewReader/NewParallelReader.
+func buildStoredZip(entries map[string][]byte, order []string) []byte {
+	var buf bytes.Buffer
+	for _, name := range order {
+		data := entries[name]
+		writeLocalHeader(&buf, 0, uint16(0), crc32.ChecksumIEEE(data), uint32(len(data)), uint32(len(data)), name, nil)
+		buf.Write(data)
+	}
+	writeTerminator(&buf)
+	return buf.Bytes()
+}
+
+func TestParallelReader_OrderAndContents(t *testing.T) {
+	order := []string{"a.txt", "b.txt", "c.txt"}
+	entries := map[string][]byte{
+		"a.txt": []byte("first entry"),
+		"b.txt": []byte("second entry, a little longer"),
+		"c.txt": []byte("third"),
+	}
+	raw := buildStoredZip(entries, order)
+
+	zr := NewParallelReader(bytes.NewReader(raw), ParallelReaderOptions{})
+	defer func() { _ = zr.Close() }()
+
+	for _, name := range order {
+		entry, err := zr.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if entry.Name != name {
+			t.Fatalf("Name = %q, want %q", entry.Name, name)
+		}
+		got, err := io.ReadAll(entry)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if !bytes.Equal(got, entries[name]) {
+			t.Fatalf("entry %q data = %q, want %q", name, got, entries[name])
+		}
+	}
+	if _, err := zr.Next(); err != io.EOF {
+		t.Fatalf("final Next() = %v, want io.EOF", err)
+	}
+}
+
+func TestParallelReader_SpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 1024)
+	raw := buildStoredZip(map[string][]byte{"big.bin": data}, []string{"big.bin"})
+
+	zr := NewParallelReader(bytes.NewReader(raw), ParallelReaderOptions{SpillThreshold: 100, SpillDir: dir})
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "zipstream-prefetch-*.tmp"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("spill files in %s = %v, want exactly one", dir, matches)
+	}
+
+	got, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("spilled entry data mismatch")
+	}
+
+	if err := zr.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "zipstream-prefetch-*.tmp")); len(matches) != 0 {
+		t.Fatalf("spill file(s) %v still present after Close", matches)
+	}
+}
+
+func TestParallelReader_MaxEntryBytesExceeded(t *testing.T) {
+	data := bytes.Repeat([]byte("y"), 1024)
+	raw := buildStoredZip(map[string][]byte{"big.bin": data}, []string{"big.bin"})
+
+	zr := NewParallelReader(bytes.NewReader(raw), ParallelReaderOptions{MaxEntryBytes: 100})
+	defer func() { _ = zr.Close() }()
+
+	if _, err := zr.Next(); err == nil {
+		t.Fatal("expected an error for an entry exceeding MaxEntryBytes, got nil")
+	}
+}
+
+func TestParallelReader_MaxEntryBytesWithinLimit(t *testing.T) {
+	data := []byte("small entry")
+	raw := buildStoredZip(map[string][]byte{"small.bin": data}, []string{"small.bin"})
+
+	zr := NewParallelReader(bytes.NewReader(raw), ParallelReaderOptions{MaxEntryBytes: int64(len(data))})
+	defer func() { _ = zr.Close() }()
+
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	got, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("data = %q, want %q", got, data)
+	}
+}
+
+func TestParallelReader_PropagatesEntryError(t *testing.T) {
+	// A local file header signature with no complete header following it: readEntry fails
+	// partway through the underlying stream's first entry, and that failure must surface
+	// from Next rather than being swallowed or hung on.
+	var buf bytes.Buffer
+	writeLocalHeader(&buf, 0, uint16(0), 0, 0, 0, "broken.bin", nil)
+	raw := buf.Bytes()[:len(buf.Bytes())-4] // truncate partway through the header
+
+	zr := NewParallelReader(bytes.NewReader(raw), ParallelReaderOptions{})
+	defer func() { _ = zr.Close() }()
+
+	if _, err := zr.Next(); err == nil {
+		t.Fatal("expected an error for a truncated local file header, got nil")
+	} else if errors.Is(err, io.EOF) {
+		t.Fatalf("Next() = io.EOF, want a non-EOF parse error")
+	}
+}
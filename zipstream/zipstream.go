@@ -10,40 +10,127 @@ import (
 	"archive/zip"
 	"bufio"
 	"compress/flate"
+	"crypto/hmac"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"hash"
+	"hash/crc32"
 	"io"
 )
 
 const (
 	headerIdentifierLen      = 4
 	fileHeaderLen            = 26
-	dataDescriptorLen        = 16 // four uint32: descriptor signature, crc32, compressed size, size
 	fileHeaderSignature      = 0x04034b50
 	directoryHeaderSignature = 0x02014b50
 	directoryEndSignature    = 0x06054b50
 	dataDescriptorSignature  = 0x08074b50
 	zip64ExtraID             = 0x0001 // Zip64 extended information
+	zstdMethod               = 93     // APPNOTE 4.4.5: Zstandard
 )
 
+// ErrChecksum indicates an entry's decompressed data didn't match the CRC-32 or size
+// recorded in its local file header or data descriptor, e.g. from a truncated or
+// corrupted artifact download.
+var ErrChecksum = errors.New("zipstream: checksum or size mismatch")
+
+// Decompressor returns a reader that decompresses data for a registered compression
+// method, mirroring archive/zip.Decompressor. The returned ReadCloser is closed once an
+// entry has been fully read, before the next entry's header is parsed.
+type Decompressor func(r io.Reader) io.ReadCloser
+
+func newStoreReader(r io.Reader) io.ReadCloser {
+	return io.NopCloser(r)
+}
+
+func newFlateReader(r io.Reader) io.ReadCloser {
+	return flate.NewReader(r)
+}
+
+// zstdError reports a zstd decoder construction failure on the first Read, matching the
+// Decompressor signature's lack of an error return (also how archive/zip's Decompressor
+// type is meant to be used).
+type zstdError struct{ err error }
+
+func (z zstdError) Read([]byte) (int, error) { return 0, z.err }
+func (z zstdError) Close() error             { return nil }
+
+// zstdDecoderCloser adapts *zstd.Decoder's Close (which has no error return) to
+// io.ReadCloser.
+type zstdDecoderCloser struct{ *zstd.Decoder }
+
+func (z zstdDecoderCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+func newZstdReader(r io.Reader) io.ReadCloser {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return zstdError{err}
+	}
+	return zstdDecoderCloser{dec}
+}
+
 type Reader struct {
-	r            io.Reader
-	rBuf         *bufio.Reader
-	fr           io.ReadCloser
-	localFileEnd bool
-	curEntry     *Entry
+	r             io.Reader
+	decompressors map[uint16]Decompressor
+	localFileEnd  bool
+	curEntry      *Entry
+	// password decrypts WinZip AES entries (method 99). Left empty, such entries fail to
+	// read instead of being silently skipped.
+	password string
 }
 
 func NewReader(r io.Reader) *Reader {
 	return &Reader{
-		r:  r,
-		fr: nil,
+		r: r,
+		decompressors: map[uint16]Decompressor{
+			uint16(zip.Store):   newStoreReader,
+			uint16(zip.Deflate): newFlateReader,
+			zstdMethod:          newZstdReader,
+		},
 	}
 }
 
+// RegisterDecompressor registers, or overrides, the decompressor used for method,
+// mirroring archive/zip.Reader.RegisterDecompressor. Store (0), Deflate (8), and zstd (93)
+// are registered by default; callers can add others (bzip2, xz, LZMA...) without patching
+// this package.
+func (z *Reader) RegisterDecompressor(method uint16, dcomp Decompressor) {
+	z.decompressors[method] = dcomp
+}
+
+// SetPassword sets the password used to decrypt WinZip AES-encrypted entries (compression
+// method 99, identified by an AE-x 0x9901 extra field). It must be called before Next is
+// called for such an entry. Entries using other encryption schemes remain unsupported.
+func (z *Reader) SetPassword(password string) {
+	z.password = password
+}
+
 type Entry struct {
 	zip.FileHeader
 	r io.Reader
+	// closer closes the entry's decompressor once it's been fully read.
+	closer io.Closer
+	// zip64 records whether the local header used 0xFFFFFFFF size sentinels, so the
+	// trailing data descriptor (if any) is known to use 8-byte size fields.
+	zip64 bool
+	// compressed counts bytes read off the underlying stream for this entry, to verify
+	// against the recorded compressed size.
+	compressed *countingReader
+	// crc and uncompressedN are updated on every Read, to verify against the recorded
+	// CRC-32 and uncompressed size once the entry is fully read.
+	crc           hash.Hash32
+	uncompressedN uint64
+	// skipCRC is set for AE-2 WinZip AES entries, whose local header/data descriptor CRC-32
+	// is always zero; integrity is checked via aesMAC instead.
+	skipCRC bool
+	// aesMAC is non-nil for WinZip AES entries. Its running HMAC-SHA1 is checked against the
+	// entry's trailing authentication tag once the ciphertext has been fully read.
+	aesMAC hash.Hash
 }
 
 func (e *Entry) hasDataDescriptor() bool {
@@ -56,7 +143,64 @@ func (e *Entry) IsDir() bool {
 }
 
 func (e *Entry) Read(p []byte) (n int, err error) {
-	return e.r.Read(p)
+	n, err = e.r.Read(p)
+	if n > 0 {
+		e.crc.Write(p[:n])
+		e.uncompressedN += uint64(n)
+	}
+	return n, err
+}
+
+// verify compares the entry's streamed CRC-32 and byte counts against the values recorded
+// in either the local file header or the trailing data descriptor, returning ErrChecksum if
+// they disagree.
+func (e *Entry) verify(wantCRC32 uint32, wantCompressedSize, wantUncompressedSize uint64) error {
+	gotCompressedSize := uint64(0)
+	if e.compressed != nil {
+		gotCompressedSize = uint64(e.compressed.n)
+	}
+	gotCRC32 := e.crc.Sum32()
+	if !e.skipCRC && gotCRC32 != wantCRC32 || gotCompressedSize != wantCompressedSize || e.uncompressedN != wantUncompressedSize {
+		return fmt.Errorf(
+			"%w: entry %q: crc32 %08x != %08x, compressed size %d != %d, uncompressed size %d != %d",
+			ErrChecksum, e.Name,
+			gotCRC32, wantCRC32,
+			gotCompressedSize, wantCompressedSize,
+			e.uncompressedN, wantUncompressedSize,
+		)
+	}
+	return nil
+}
+
+// countingReader wraps a reader to track how many bytes have been read off it, so a
+// streamed entry's compressed size can be verified without buffering it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadByte delegates to the underlying reader's own ReadByte. Without this, countingReader
+// wouldn't satisfy io.ByteReader, and compress/flate.NewReader would wrap it in a private
+// bufio.Reader of its own to get one anyway — silently over-reading past the end of a
+// streamed entry's compressed data into the trailing data descriptor, since those buffered
+// bytes are never counted or given back. readEntry only takes the countingReader.Read path
+// that needs this (the "unknown compressed size" case) after ensuring r is an io.ByteReader.
+func (c *countingReader) ReadByte() (byte, error) {
+	br, ok := c.r.(io.ByteReader)
+	if !ok {
+		return 0, fmt.Errorf("zipstream: countingReader requires an io.ByteReader")
+	}
+	b, err := br.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
 }
 
 //goland:noinspection GoDeprecation
@@ -103,15 +247,19 @@ func (z *Reader) readEntry() (*Entry, error) {
 	entry.Extra = nameAndExtraBuf[filenameLen:]
 
 	entry.NonUTF8 = flags&0x800 == 0
-	if flags&1 == 1 {
-		return nil, fmt.Errorf("encrypted ZIP entry not supported")
+	if flags&1 == 1 && method != aeMethod {
+		return nil, fmt.Errorf("encrypted ZIP entry not supported (only WinZip AES is)")
 	}
-	if flags&8 == 8 && method != zip.Deflate {
-		return nil, fmt.Errorf("only DEFLATED entries can have data descriptor")
+	if flags&8 == 8 && method != zip.Deflate && method != zstdMethod && method != aeMethod {
+		return nil, fmt.Errorf("only DEFLATED, zstd, or AES entries can have data descriptor")
 	}
 
 	needCSize := entry.CompressedSize == ^uint32(0)
 	needUSize := entry.UncompressedSize == ^uint32(0)
+	entry.zip64 = needCSize || needUSize
+
+	var ae aeExtra
+	var haveAE bool
 
 	ler := readBuf(entry.Extra)
 	for len(ler) >= 4 { // need at least tag and size
@@ -142,6 +290,11 @@ func (z *Reader) readEntry() (*Entry, error) {
 				}
 				entry.CompressedSize64 = fieldBuf.uint64()
 			}
+		case aeExtraID:
+			if parsed, ok := parseAEExtra(fieldBuf); ok {
+				ae = parsed
+				haveAE = true
+			}
 		}
 	}
 
@@ -149,30 +302,78 @@ func (z *Reader) readEntry() (*Entry, error) {
 		return nil, zip.ErrFormat
 	}
 
-	if method == zip.Store {
-		entry.r = io.LimitReader(z.r, int64(entry.UncompressedSize64))
-	} else if method == zip.Deflate {
-		var reader io.Reader
-		if entry.CompressedSize64 > 0 {
-			reader = io.LimitReader(z.r, int64(entry.CompressedSize64))
-		} else {
-			// unknown size; read until deflate EOF,
-			// but we need z.r to be an io.ByteReader for flate to not overread
+	realMethod := method
+	cr := &countingReader{r: z.r}
+	var reader io.Reader
+	var skipCRC bool
+	var entryMAC hash.Hash
+
+	if method == aeMethod {
+		if !haveAE {
+			return nil, fmt.Errorf("zipstream: entry %q uses AES but has no AE-x extra field", entry.Name)
+		}
+		if z.password == "" {
+			return nil, fmt.Errorf("zipstream: entry %q is AES encrypted but no password is set", entry.Name)
+		}
+		saltLen := aesSaltLen(ae.strength)
+		if saltLen == 0 {
+			return nil, fmt.Errorf("zipstream: entry %q has unknown AES strength %d", entry.Name, ae.strength)
+		}
+		if entry.CompressedSize64 == 0 {
+			// Without a known compressed size we can't locate the boundary between the
+			// ciphertext and the trailing authentication tag.
+			return nil, fmt.Errorf("zipstream: AES entry %q has unknown compressed size", entry.Name)
+		}
+
+		saltAndPV := make([]byte, saltLen+2)
+		if _, err := io.ReadFull(cr, saltAndPV); err != nil {
+			return nil, fmt.Errorf("unable to read AES salt: %w", err)
+		}
+
+		cipherLen := int64(entry.CompressedSize64) - int64(saltLen) - 2 - aesMACLen
+		if cipherLen < 0 {
+			return nil, fmt.Errorf("zipstream: AES entry %q compressed size too small", entry.Name)
+		}
+
+		decrypted, mac, err := newAESDecryptor(z.password, ae, saltAndPV[:saltLen], saltAndPV[saltLen:], io.LimitReader(cr, cipherLen))
+		if err != nil {
+			return nil, err
+		}
+		reader = decrypted
+		entryMAC = mac
+		realMethod = ae.method
+		skipCRC = ae.version == 2
+	} else {
+		switch {
+		case method == uint16(zip.Store):
+			reader = io.LimitReader(cr, int64(entry.UncompressedSize64))
+		case entry.CompressedSize64 > 0:
+			reader = io.LimitReader(cr, int64(entry.CompressedSize64))
+		default:
+			// Unknown compressed size (a streamed entry with a data descriptor); read until
+			// the decompressor's own EOF, but we need z.r to be an io.ByteReader so a
+			// flate-style decompressor doesn't overread into the next entry's header.
 			if _, ok := z.r.(io.ByteReader); !ok {
 				z.r = bufio.NewReader(z.r)
+				cr.r = z.r
 			}
-			reader = z.r
-		}
-		if z.fr == nil {
-			z.fr = flate.NewReader(reader)
-		} else {
-			z.fr.(flate.Resetter).Reset(reader, nil)
+			reader = cr
 		}
-		entry.r = z.fr
-	} else {
-		return nil, fmt.Errorf("unknown compression method %d", method)
 	}
 
+	dcomp, ok := z.decompressors[realMethod]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression method %d", realMethod)
+	}
+
+	rc := dcomp(reader)
+	entry.r = rc
+	entry.closer = rc
+	entry.compressed = cr
+	entry.crc = crc32.NewIEEE()
+	entry.skipCRC = skipCRC
+	entry.aesMAC = entryMAC
+
 	return entry, nil
 }
 
@@ -185,11 +386,30 @@ func (z *Reader) Next() (*Entry, error) {
 		if _, err := io.Copy(io.Discard, z.curEntry); err != nil {
 			return nil, fmt.Errorf("read previous file data fail: %w", err)
 		}
-		// Read the data descriptor if present.
+		if z.curEntry.closer != nil {
+			if err := z.curEntry.closer.Close(); err != nil {
+				return nil, fmt.Errorf("close previous entry decompressor fail: %w", err)
+			}
+		}
+		if z.curEntry.aesMAC != nil {
+			tag := make([]byte, aesMACLen)
+			if _, err := io.ReadFull(z.curEntry.compressed, tag); err != nil {
+				return nil, fmt.Errorf("read AES authentication tag fail: %w", err)
+			}
+			if !hmac.Equal(tag, z.curEntry.aesMAC.Sum(nil)[:aesMACLen]) {
+				return nil, ErrAESAuthentication
+			}
+		}
 		if z.curEntry.hasDataDescriptor() {
-			if err := readDataDescriptor(z.r); err != nil {
+			desc, err := readDataDescriptor(z.r, z.curEntry.zip64)
+			if err != nil {
 				return nil, fmt.Errorf("read previous entry's data descriptor fail: %w", err)
 			}
+			if err := z.curEntry.verify(desc.crc32, desc.compressedSize, desc.uncompressedSize); err != nil {
+				return nil, err
+			}
+		} else if err := z.curEntry.verify(z.curEntry.CRC32, z.curEntry.CompressedSize64, z.curEntry.UncompressedSize64); err != nil {
+			return nil, err
 		}
 	}
 	headerIDBuf := make([]byte, headerIdentifierLen)
@@ -212,8 +432,24 @@ func (z *Reader) Next() (*Entry, error) {
 	return entry, nil
 }
 
-func readDataDescriptor(r io.Reader) error {
-	var buf [dataDescriptorLen]byte
+// dataDescriptor holds the crc32/compressed-size/uncompressed-size fields that trail an
+// entry whose local header set the "data descriptor follows" flag.
+type dataDescriptor struct {
+	crc32            uint32
+	compressedSize   uint64
+	uncompressedSize uint64
+}
+
+// readDataDescriptor reads the data descriptor following an entry's compressed data.
+// zip64 selects the 8-byte size fields APPNOTE specifies once either size sentinel
+// (0xFFFFFFFF) was seen in the entry's local header; otherwise the sizes are 4 bytes.
+func readDataDescriptor(r io.Reader, zip64 bool) (dataDescriptor, error) {
+	sizeFieldLen := 4
+	if zip64 {
+		sizeFieldLen = 8
+	}
+	fixedLen := 4 + sizeFieldLen*2 // crc32, compressed size, uncompressed size
+
 	// The spec says: "Although not originally assigned a
 	// signature, the value 0x08074b50 has commonly been adopted
 	// as a signature value for the data descriptor record.
@@ -222,24 +458,36 @@ func readDataDescriptor(r io.Reader) error {
 	// descriptors and should account for either case when reading
 	// ZIP files to ensure compatibility."
 	//
-	// dataDescriptorLen includes the size of the signature but
-	// first read just those 4 bytes to see if it exists.
-	_, err := io.ReadFull(r, buf[:4])
-	if err != nil {
-		return err
+	// Read just the first 4 bytes to see if the signature is present.
+	var sig [4]byte
+	if _, err := io.ReadFull(r, sig[:]); err != nil {
+		return dataDescriptor{}, err
 	}
-	off := 0
-	maybeSig := readBuf(buf[:4])
-	if maybeSig.uint32() != dataDescriptorSignature {
-		// No data descriptor signature. Keep these four bytes.
-		off += 4
-	}
-	_, err = io.ReadFull(r, buf[off:12])
-	if err != nil {
-		return err
+
+	fixed := make([]byte, fixedLen)
+	if binary.LittleEndian.Uint32(sig[:]) == dataDescriptorSignature {
+		if _, err := io.ReadFull(r, fixed); err != nil {
+			return dataDescriptor{}, err
+		}
+	} else {
+		// No signature: these four bytes are the start of the crc32 field.
+		copy(fixed, sig[:])
+		if _, err := io.ReadFull(r, fixed[4:]); err != nil {
+			return dataDescriptor{}, err
+		}
 	}
 
-	return nil
+	br := readBuf(fixed)
+	var d dataDescriptor
+	d.crc32 = br.uint32()
+	if zip64 {
+		d.compressedSize = br.uint64()
+		d.uncompressedSize = br.uint64()
+	} else {
+		d.compressedSize = uint64(br.uint32())
+		d.uncompressedSize = uint64(br.uint32())
+	}
+	return d, nil
 }
 
 type readBuf []byte
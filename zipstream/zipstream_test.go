@@ -0,0 +1,210 @@
+package zipstream
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// writeLocalHeader writes a minimal local file header (no data descriptor trailer logic;
+// callers decide whether flags requests one) followed by name and extra, mirroring the
+// layout zipstream.Reader.readEntry expects.
+func writeLocalHeader(buf *bytes.Buffer, flags, method uint16, crc32Sum, compSize, uncompSize uint32, name string, extra []byte) {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint32(hdr[:], fileHeaderSignature)
+	buf.Write(hdr[:])
+
+	le16 := func(v uint16) { var b [2]byte; binary.LittleEndian.PutUint16(b[:], v); buf.Write(b[:]) }
+	le32 := func(v uint32) { var b [4]byte; binary.LittleEndian.PutUint32(b[:], v); buf.Write(b[:]) }
+
+	le16(20) // reader version
+	le16(flags)
+	le16(method)
+	le16(0) // modified time
+	le16(0) // modified date
+	le32(crc32Sum)
+	le32(compSize)
+	le32(uncompSize)
+	le16(uint16(len(name)))
+	le16(uint16(len(extra)))
+	buf.WriteString(name)
+	buf.Write(extra)
+}
+
+// writeDataDescriptor writes a (signed) trailing data descriptor with 4-byte size fields.
+func writeDataDescriptor(buf *bytes.Buffer, crc32Sum, compSize, uncompSize uint32) {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint32(hdr[:], dataDescriptorSignature)
+	buf.Write(hdr[:])
+	le32 := func(v uint32) { var b [4]byte; binary.LittleEndian.PutUint32(b[:], v); buf.Write(b[:]) }
+	le32(crc32Sum)
+	le32(compSize)
+	le32(uncompSize)
+}
+
+// writeTerminator writes a 4-byte signature Next() recognizes as the start of the central
+// directory, so a built stream doesn't need a full end-of-central-directory record.
+func writeTerminator(buf *bytes.Buffer) {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint32(hdr[:], directoryEndSignature)
+	buf.Write(hdr[:])
+}
+
+func deflateBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		t.Fatalf("flate.NewWriter: %v", err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		t.Fatalf("flate write: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("flate close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReader_StoredEntryRoundTrip(t *testing.T) {
+	data := []byte("hello, decompal")
+	var buf bytes.Buffer
+	writeLocalHeader(&buf, 0, uint16(0), crc32.ChecksumIEEE(data), uint32(len(data)), uint32(len(data)), "report.json", nil)
+	buf.Write(data)
+	writeTerminator(&buf)
+
+	zr := NewReader(&buf)
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if entry.Name != "report.json" {
+		t.Fatalf("Name = %q, want report.json", entry.Name)
+	}
+	got, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("data = %q, want %q", got, data)
+	}
+	if _, err := zr.Next(); err != io.EOF {
+		t.Fatalf("second Next() = %v, want io.EOF", err)
+	}
+}
+
+func TestReader_DataDescriptorValid(t *testing.T) {
+	data := []byte("streamed entry contents, long enough to compress decently")
+	compressed := deflateBytes(t, data)
+	crc := crc32.ChecksumIEEE(data)
+
+	var buf bytes.Buffer
+	writeLocalHeader(&buf, 8, uint16(flateMethod), 0, 0, 0, "report.binpb", nil)
+	buf.Write(compressed)
+	writeDataDescriptor(&buf, crc, uint32(len(compressed)), uint32(len(data)))
+	writeTerminator(&buf)
+
+	zr := NewReader(&buf)
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	got, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("data = %q, want %q", got, data)
+	}
+	// verify() only runs once the next entry (or EOF) is requested.
+	if _, err := zr.Next(); err != io.EOF {
+		t.Fatalf("second Next() = %v, want io.EOF", err)
+	}
+}
+
+func TestReader_DataDescriptorTruncated(t *testing.T) {
+	data := []byte("streamed entry")
+	compressed := deflateBytes(t, data)
+	crc := crc32.ChecksumIEEE(data)
+
+	var full bytes.Buffer
+	writeLocalHeader(&full, 8, uint16(flateMethod), 0, 0, 0, "report.json", nil)
+	full.Write(compressed)
+	writeDataDescriptor(&full, crc, uint32(len(compressed)), uint32(len(data)))
+	writeTerminator(&full)
+
+	// Truncate partway through the data descriptor that follows the compressed data.
+	truncated := full.Bytes()[:len(full.Bytes())-6]
+
+	zr := NewReader(bytes.NewReader(truncated))
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if _, err := io.ReadAll(entry); err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if _, err := zr.Next(); err == nil {
+		t.Fatal("expected an error reading a truncated data descriptor, got nil")
+	}
+}
+
+func TestReader_ChecksumMismatch(t *testing.T) {
+	data := []byte("streamed entry")
+	compressed := deflateBytes(t, data)
+	wrongCRC := crc32.ChecksumIEEE(data) ^ 0xFFFFFFFF
+
+	var buf bytes.Buffer
+	writeLocalHeader(&buf, 8, uint16(flateMethod), 0, 0, 0, "report.json", nil)
+	buf.Write(compressed)
+	writeDataDescriptor(&buf, wrongCRC, uint32(len(compressed)), uint32(len(data)))
+	writeTerminator(&buf)
+
+	zr := NewReader(&buf)
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if _, err := io.ReadAll(entry); err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if _, err := zr.Next(); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("second Next() = %v, want ErrChecksum", err)
+	}
+}
+
+func TestReader_Zip64Sizes(t *testing.T) {
+	data := []byte("stored entry using zip64 size fields")
+
+	extra := make([]byte, 20)
+	binary.LittleEndian.PutUint16(extra[0:2], zip64ExtraID)
+	binary.LittleEndian.PutUint16(extra[2:4], 16)
+	binary.LittleEndian.PutUint64(extra[4:12], uint64(len(data)))  // uncompressed size
+	binary.LittleEndian.PutUint64(extra[12:20], uint64(len(data))) // compressed size
+
+	var buf bytes.Buffer
+	writeLocalHeader(&buf, 0, uint16(0), crc32.ChecksumIEEE(data), ^uint32(0), ^uint32(0), "report.json", extra)
+	buf.Write(data)
+	writeTerminator(&buf)
+
+	zr := NewReader(&buf)
+	entry, err := zr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	got, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("data = %q, want %q", got, data)
+	}
+}
+
+// flateMethod is zip.Deflate's numeric value, spelled out locally so this test file doesn't
+// need to import archive/zip just for one constant.
+const flateMethod = 8